@@ -1,199 +1,634 @@
-package main
+// Package client is a Go client of the v1 API of the key value store. It's
+// configured with the client addresses of the cluster, sends requests to the
+// leader once it knows it from a response and follows the leader hints of
+// followers. Reads and writes are retried on other nodes with an exponential
+// backoff, writes are sent within a client session so retrying them is safe:
+// the cluster applies every write at most once, see database.WithSession.
+// Cluster operations such as adding a member aren't retried since they could
+// be applied twice. A Client is safe for concurrent use, concurrent writes are
+// sent within sessions of their own
+package client
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"fmt"
+	"io"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/ssergomol/raft/logger"
+	"github.com/ssergomol/raft/database"
+	"github.com/ssergomol/raft/observer"
 )
 
-func ValidateSet(cmd string) error {
-	cmdSplits := strings.Split(cmd, " ")
-	if cmdSplits[0] != "SET" {
-		return errors.New("invalid SET command, bad request")
+const (
+	DefaultRequestTimeout = 5 * time.Second
+	DefaultMaxRetries     = 3
+	DefaultRetryBackoff   = 100 * time.Millisecond
+)
+
+var (
+	ErrKeyNotFound     = errors.New("key not found")
+	ErrConditionFailed = errors.New("condition failed")
+	ErrCompacted       = errors.New("revision has been compacted")
+	ErrNoLeader        = errors.New("no leader is available")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrStaleSequence   = errors.New("stale session sequence")
+)
+
+type Config struct {
+	// Endpoints are the client addresses of the nodes, as host:port or URLs
+	Endpoints []string
+	// TLS enables https, it's also used for endpoints given without a scheme
+	TLS *tls.Config
+	// Username and Password authenticate every request once auth is enabled
+	Username string
+	Password string
+
+	// RequestTimeout bounds every attempt of a request, the deadline of the
+	// context passed to a method bounds the request with all its retries
+	RequestTimeout time.Duration
+	MaxRetries     int
+	RetryBackoff   time.Duration
+}
+
+// Error is a request the cluster answered with an error status, errors.Is
+// matches it with the Err variables of the package
+type Error struct {
+	StatusCode int
+	Message    string
+	LeaderId   string
+	LeaderAddr string
+}
+
+func (e *Error) Error() string {
+	return strconv.Itoa(e.StatusCode) + " " + e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return target == ErrKeyNotFound
+	case http.StatusConflict:
+		return target == ErrConditionFailed
+	case http.StatusGone:
+		return target == ErrCompacted
+	case http.StatusServiceUnavailable:
+		return target == ErrNoLeader
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return target == ErrUnauthorized
+	case http.StatusPreconditionFailed:
+		return target == ErrStaleSequence
 	}
+	return false
+}
+
+// response is the body of the v1 API errors and key responses
+type response struct {
+	Error      string `json:"error"`
+	Key        string `json:"key"`
+	Value      int    `json:"value"`
+	Version    int    `json:"version"`
+	TTL        int64  `json:"ttl"`
+	LeaderId   string `json:"leader_id"`
+	LeaderAddr string `json:"leader_addr"`
+}
+
+type Client struct {
+	config Config
+	http   *http.Client
+	scheme string
+
+	mu       sync.Mutex
+	leader   string
+	next     int
+	sessions []*session
+}
+
+// session is a client session of the cluster. The cluster only keeps the
+// result of the last request of a session, so a session has at most one
+// request in flight: a write takes an idle session, or a new one, for all its
+// attempts and gives it back once it's answered
+type session struct {
+	clientId string
+	seq      int
+}
 
-	if len(cmdSplits) != 3 {
-		return errors.New("need a key for GET/DELETE operation, bad request")
+// New returns a client of the cluster at the given endpoints
+func New(config Config) (*Client, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, errors.New("need at least one endpoint")
+	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = DefaultRequestTimeout
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultMaxRetries
 	}
+	if config.RetryBackoff == 0 {
+		config.RetryBackoff = DefaultRetryBackoff
+	}
+	c := &Client{config: config, scheme: "http"}
+	if config.TLS != nil {
+		c.scheme = "https"
+	}
+	endpoints := make([]string, 0, len(config.Endpoints))
+	for _, endpoint := range config.Endpoints {
+		endpoints = append(endpoints, c.endpointURL(endpoint))
+	}
+	c.config.Endpoints = endpoints
+	c.http = &http.Client{
+		Transport: &http.Transport{TLSClientConfig: config.TLS},
+		// leader redirects are followed by do, which remembers the leader
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return c, nil
+}
 
-	_, err := strconv.Atoi(cmdSplits[2])
-	if err != nil {
-		fmt.Println(err)
-		return errors.New("not a valid integer value, bad request")
+func (c *Client) endpointURL(endpoint string) string {
+	if strings.Contains(endpoint, "://") {
+		return strings.TrimSuffix(endpoint, "/")
 	}
+	return c.scheme + "://" + endpoint
+}
+
+// Leader returns the endpoint of the last known leader, "" if there is none
+func (c *Client) Leader() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.leader
+}
 
-	return nil
+func (c *Client) setLeader(endpoint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.leader = endpoint
 }
 
-func ValidateDelete(cmd string) error {
-	cmdSplits := strings.Split(cmd, " ")
+// endpoint returns the leader if it's known and the next endpoint otherwise
+func (c *Client) endpoint() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if cmdSplits[0] != "DELETE" {
-		return errors.New("invalid DELETE command, bad request")
+	if c.leader != "" {
+		return c.leader
 	}
+	endpoint := c.config.Endpoints[c.next%len(c.config.Endpoints)]
+	c.next++
+	return endpoint
+}
+
+// takeSession returns an idle session, or a new one if all of them are in use
+func (c *Client) takeSession() *session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if len(cmdSplits) != 2 {
-		return errors.New("need a key for GET/DELETE operation, bad request")
+	if n := len(c.sessions); n > 0 {
+		sess := c.sessions[n-1]
+		c.sessions = c.sessions[:n-1]
+		return sess
 	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &session{clientId: hex.EncodeToString(id)}
+}
+
+func (c *Client) putSession(sess *session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return nil
+	c.sessions = append(c.sessions, sess)
 }
 
-func ValidateGet(cmd string) error {
-	cmdSplits := strings.Split(cmd, " ")
-	if cmdSplits[0] != "GET" {
-		return errors.New("invalid GET command, bad request")
+// request is a request with everything needed to send it again
+type request struct {
+	method      string
+	path        string
+	contentType string
+	body        []byte
+	// inSession sends a write within a client session, so it can be retried
+	inSession bool
+}
+
+// do sends a request until it gets an answer that isn't a redirect or a 503,
+// trying the other endpoints in between. Only reads and writes within a
+// session are sent again after a failure or a 503, other requests may have
+// been applied and only follow redirects. It returns the body of a successful
+// response and an *Error for the other ones
+func (c *Client) do(ctx context.Context, req request) ([]byte, error) {
+	var sess *session
+	if req.inSession {
+		sess = c.takeSession()
+		defer c.putSession(sess)
+		sess.seq++
+	}
+	retry := req.method == http.MethodGet || sess != nil
+
+	backoff := c.config.RetryBackoff
+	var lastErr error
+	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		endpoint := c.endpoint()
+		status, header, body, err := c.send(ctx, endpoint, req, sess)
+		if err == nil && header.Get("X-Raft-Leader") != "" {
+			c.setLeader(c.endpointURL(header.Get("X-Raft-Leader")))
+		}
+		if err == nil && status < 300 {
+			return body, nil
+		}
+		if err == nil && status != http.StatusTemporaryRedirect && (status != http.StatusServiceUnavailable || !retry) {
+			return nil, newError(status, body)
+		}
+		if err != nil && !retry {
+			c.setLeader("")
+			return nil, err
+		}
+
+		// the leader hint of a redirect or a 503 is tried right away
+		hint := ""
+		if err == nil {
+			lastErr = newError(status, body)
+			hint = c.leaderHint(header, body)
+		} else {
+			lastErr = err
+		}
+		if hint != "" && hint != endpoint {
+			c.setLeader(hint)
+			continue
+		}
+		c.setLeader("")
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
 	}
+	return nil, lastErr
+}
+
+func (c *Client) send(ctx context.Context, endpoint string, req request, sess *session) (int, http.Header, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.RequestTimeout)
+	defer cancel()
 
-	if len(cmdSplits) != 2 {
-		return errors.New("need a key for GET/DELETE operation, bad request")
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, endpoint+req.path, bytes.NewReader(req.body))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	if sess != nil {
+		httpReq.Header.Set("X-Client-Id", sess.clientId)
+		httpReq.Header.Set("X-Client-Seq", strconv.Itoa(sess.seq))
+	}
+	if c.config.Username != "" {
+		httpReq.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return 0, nil, nil, err
 	}
+	defer resp.Body.Close()
 
-	return nil
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return resp.StatusCode, resp.Header, body, nil
 }
 
-func main() {
-	var addr string = "localhost"
+// leaderHint returns the leader endpoint of a redirect or a leader address in
+// the body of a 503
+func (c *Client) leaderHint(header http.Header, body []byte) string {
+	if location, err := url.Parse(header.Get("Location")); err == nil && location.Host != "" {
+		return location.Scheme + "://" + location.Host
+	}
+	var res response
+	if json.Unmarshal(body, &res) == nil && res.LeaderAddr != "" {
+		return c.endpointURL(res.LeaderAddr)
+	}
+	return ""
+}
 
-	for {
-		allServers, _ := logger.ListAllServers()
-		rand.Seed(time.Now().UnixNano())
+func newError(status int, body []byte) *Error {
+	e := &Error{StatusCode: status, Message: strings.TrimSpace(string(body))}
+	var res response
+	if json.Unmarshal(body, &res) == nil && res.Error != "" {
+		e.Message = res.Error
+		e.LeaderId = res.LeaderId
+		e.LeaderAddr = res.LeaderAddr
+	}
+	return e
+}
 
-		// Create a slice to store the values
-		ports := make([]int, 0, len(allServers))
+func (c *Client) keyValue(ctx context.Context, req request) (database.KeyValue, error) {
+	body, err := c.do(ctx, req)
+	if err != nil {
+		return database.KeyValue{}, err
+	}
+	var res response
+	if err := json.Unmarshal(body, &res); err != nil {
+		return database.KeyValue{}, err
+	}
+	return database.KeyValue{Key: res.Key, Value: res.Value, Version: res.Version, TTL: res.TTL}, nil
+}
 
-		// Iterate over the map and append values to the slice
-		for _, port := range allServers {
-			ports = append(ports, port)
-		}
-		// Generate a random permutation of servers
-		randServers := rand.Perm(len(allServers))
-		// currentServer := 0
-		var randomPort string
-
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Print(">")
-		text, _ := reader.ReadString('\n')
-		text = strings.TrimRight(text, "\n")
-
-		reqBody := []byte(text)
-		cmdSplits := strings.Split(text, " ")
-
-		var err error
-		var resp *http.Response
-		switch cmdSplits[0] {
-		case "GET":
-			err = ValidateGet(text)
-			if err != nil {
-				break
-			}
+func keyPath(key string) string {
+	return "/v1/kv/" + url.PathEscape(key)
+}
 
-			for _, serverIdx := range randServers {
-				randomPort = strconv.Itoa(ports[serverIdx])
+// Get returns the value of key
+func (c *Client) Get(ctx context.Context, key string) (database.KeyValue, error) {
+	return c.keyValue(ctx, request{method: http.MethodGet, path: keyPath(key)})
+}
 
-				baseURL := "http://" + addr + ":" + randomPort
-				parameters := url.Values{}
-				parameters.Add("key", cmdSplits[1])
-				url := fmt.Sprintf("%s?%s", baseURL, parameters.Encode())
-				resp, err = http.Get(url)
+// GetAt returns the value key had at a revision, i.e. once the log entry at
+// that index was applied
+func (c *Client) GetAt(ctx context.Context, key string, revision int) (database.KeyValue, error) {
+	return c.keyValue(ctx, request{method: http.MethodGet, path: keyPath(key) + "?rev=" + strconv.Itoa(revision)})
+}
 
-				if err == nil {
-					break
-				}
-			}
+// History returns the revisions of key that weren't compacted yet, oldest first
+func (c *Client) History(ctx context.Context, key string) ([]database.Revision, error) {
+	body, err := c.do(ctx, request{method: http.MethodGet, path: keyPath(key) + "?history=true"})
+	if err != nil {
+		return nil, err
+	}
+	var revs []database.Revision
+	err = json.Unmarshal(body, &revs)
+	return revs, err
+}
 
-			if err != nil {
-				fmt.Println("The service is down:", err)
-				return
-			}
+func (c *Client) put(ctx context.Context, key string, put map[string]interface{}) (database.KeyValue, error) {
+	body, _ := json.Marshal(put)
+	return c.keyValue(ctx, request{method: http.MethodPut, path: keyPath(key), contentType: "application/json", body: body, inSession: true})
+}
 
-		case "SET":
-			err = ValidateSet(text)
-			if err != nil {
-				break
-			}
+// Set sets key to value
+func (c *Client) Set(ctx context.Context, key string, value int) (database.KeyValue, error) {
+	return c.put(ctx, key, map[string]interface{}{"value": value})
+}
 
-			for _, serverIdx := range randServers {
-				randomPort = strconv.Itoa(ports[serverIdx])
+// SetWithTTL sets key to value until ttl, rounded down to seconds, passes
+func (c *Client) SetWithTTL(ctx context.Context, key string, value int, ttl time.Duration) (database.KeyValue, error) {
+	return c.put(ctx, key, map[string]interface{}{"value": value, "ttl": int(ttl / time.Second)})
+}
 
-				resp, err = http.Post("http://"+addr+":"+randomPort, "text/plain", bytes.NewBuffer(reqBody))
-				if err == nil {
-					break
-				}
-			}
+// CAS sets key to value if its current value is prevValue, ErrConditionFailed
+// is returned otherwise
+func (c *Client) CAS(ctx context.Context, key string, prevValue int, value int) (database.KeyValue, error) {
+	return c.put(ctx, key, map[string]interface{}{"value": value, "prev_value": prevValue})
+}
 
-			if err != nil {
-				fmt.Println("The service is down:", err)
-				return
-			}
+// SetNX sets key to value if it doesn't exist, ErrConditionFailed is returned otherwise
+func (c *Client) SetNX(ctx context.Context, key string, value int) (database.KeyValue, error) {
+	return c.put(ctx, key, map[string]interface{}{"value": value, "prev_exist": false})
+}
 
-		case "DELETE":
-			err = ValidateDelete(text)
-			if err != nil {
-				break
-			}
-			for _, serverIdx := range randServers {
-
-				randomPort = strconv.Itoa(ports[serverIdx])
-				baseURL := "http://" + addr + ":" + randomPort
-				parameters := url.Values{}
-				parameters.Add("key", cmdSplits[1])
-				url := fmt.Sprintf("%s?%s", baseURL, parameters.Encode())
-
-				req, err := http.NewRequest("DELETE", url, nil)
-				if err != nil {
-					fmt.Println("Error:", err)
-					return
-				}
-
-				client := &http.Client{}
-				resp, err = client.Do(req)
-				if err == nil {
-					break
-				}
-			}
+// Delete removes key
+func (c *Client) Delete(ctx context.Context, key string) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: keyPath(key), inSession: true})
+	return err
+}
 
-			if err != nil {
-				fmt.Println("The service is down:", err)
-				return
-			}
+// DeleteIfEqual removes key if its current value is prevValue, ErrConditionFailed
+// is returned otherwise
+func (c *Client) DeleteIfEqual(ctx context.Context, key string, prevValue int) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: keyPath(key) + "?prev_value=" + strconv.Itoa(prevValue), inSession: true})
+	return err
+}
 
-		default:
-			err = errors.New("invalid command")
-		}
+// Txn applies a transaction
+func (c *Client) Txn(ctx context.Context, txn database.Txn) (database.TxnResponse, error) {
+	var res database.TxnResponse
+	body, err := json.Marshal(txn)
+	if err != nil {
+		return res, err
+	}
+	body, err = c.do(ctx, request{method: http.MethodPost, path: "/v1/txn", contentType: "application/json", body: body, inSession: true})
+	if err != nil {
+		return res, err
+	}
+	err = json.Unmarshal(body, &res)
+	return res, err
+}
 
-		if err != nil {
-			fmt.Println("Error sending request from client:", err)
-			continue
+func (c *Client) scan(ctx context.Context, parameters url.Values) (database.ScanResponse, error) {
+	var res database.ScanResponse
+	body, err := c.do(ctx, request{method: http.MethodGet, path: "/v1/kv/?" + parameters.Encode()})
+	if err != nil {
+		return res, err
+	}
+	err = json.Unmarshal(body, &res)
+	return res, err
+}
+
+// Scan returns a page of the keys in [start, end), a page is continued by
+// passing the NextToken of the previous one as token
+func (c *Client) Scan(ctx context.Context, start string, end string, limit int, token string) (database.ScanResponse, error) {
+	parameters := url.Values{"start": {start}, "end": {end}}
+	if limit > 0 {
+		parameters.Set("limit", strconv.Itoa(limit))
+	}
+	if token != "" {
+		parameters.Set("token", token)
+	}
+	return c.scan(ctx, parameters)
+}
+
+// Keys returns a page of the keys starting with prefix, see Scan
+func (c *Client) Keys(ctx context.Context, prefix string, limit int, token string) (database.ScanResponse, error) {
+	parameters := url.Values{"prefix": {prefix}}
+	if limit > 0 {
+		parameters.Set("limit", strconv.Itoa(limit))
+	}
+	if token != "" {
+		parameters.Set("token", token)
+	}
+	return c.scan(ctx, parameters)
+}
+
+// Count returns the number of keys starting with prefix
+func (c *Client) Count(ctx context.Context, prefix string) (int, error) {
+	body, err := c.do(ctx, request{method: http.MethodGet, path: "/v1/kv/?" + url.Values{"prefix": {prefix}, "count_only": {"true"}}.Encode()})
+	if err != nil {
+		return 0, err
+	}
+	var res map[string]int
+	err = json.Unmarshal(body, &res)
+	return res["count"], err
+}
+
+// Command sends a command of the text protocol, e.g. INCR or COMPACT, and
+// returns the answer of the cluster, which also reports invalid commands. The
+// text protocol has to be enabled on the nodes, see -legacy-api
+func (c *Client) Command(ctx context.Context, command string) (string, error) {
+	body, err := c.do(ctx, request{method: http.MethodPost, path: "/", contentType: "text/plain", body: []byte(command), inSession: true})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// Member is a server of the cluster
+type Member struct {
+	Name       string `json:"name"`
+	PeerAddr   string `json:"peer_addr"`
+	ClientAddr string `json:"client_addr"`
+	Leader     bool   `json:"leader,omitempty"`
+}
+
+// MemberList returns the servers of the cluster
+func (c *Client) MemberList(ctx context.Context) ([]Member, error) {
+	body, err := c.do(ctx, request{method: http.MethodGet, path: "/v1/members"})
+	if err != nil {
+		return nil, err
+	}
+	var members []Member
+	err = json.Unmarshal(body, &members)
+	return members, err
+}
+
+// MemberAdd registers a new server with the cluster
+func (c *Client) MemberAdd(ctx context.Context, m Member) error {
+	body, _ := json.Marshal(m)
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/v1/members", contentType: "application/json", body: body})
+	return err
+}
+
+// TransferLeadership has the leader hand its leadership over to target
+func (c *Client) TransferLeadership(ctx context.Context, target string) error {
+	body, _ := json.Marshal(map[string]string{"target": target})
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/v1/leader/transfer", contentType: "application/json", body: body})
+	if err == nil {
+		c.setLeader("")
+	}
+	return err
+}
+
+// SaveSnapshot writes a snapshot of the state machine of a node to w
+func (c *Client) SaveSnapshot(ctx context.Context, w io.Writer) error {
+	body, err := c.do(ctx, request{method: http.MethodGet, path: "/v1/snapshot"})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// Events calls fn with the raft events of the node at endpoint, or of the one
+// the client currently talks to if endpoint is "", until ctx is done or fn
+// returns an error. Only events of types are passed, all of them if none is
+// given. Events describe a single node so they're neither redirected to the
+// leader nor retried, a node closes the stream of a client that falls behind
+func (c *Client) Events(ctx context.Context, endpoint string, types []observer.EventType, fn func(observer.Event) error) error {
+	base := c.endpoint()
+	if endpoint != "" {
+		base = c.endpointURL(endpoint)
+	}
+	path := "/v1/events"
+	if len(types) > 0 {
+		names := make([]string, len(types))
+		for i, t := range types {
+			names[i] = string(t)
 		}
+		path += "?" + url.Values{"type": {strings.Join(names, ",")}}.Encode()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.config.Username != "" {
+		httpReq.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return newError(resp.StatusCode, body)
+	}
 
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Println("Error reading response body:", err)
-			resp.Body.Close()
-			continue
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event observer.Event
+		if err := decoder.Decode(&event); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
 		}
+	}
+}
 
-		// fmt.Println("Response:", string(body))
-		message := string(body)
+// Observe emits the raft events of the node at endpoint on o until ctx is done
+// or the stream ends, see Events. An application running next to a node
+// registers its channels and callbacks on o, e.g. to run background work only
+// while its node is the leader
+func (c *Client) Observe(ctx context.Context, endpoint string, o *observer.Observer) error {
+	return c.Events(ctx, endpoint, nil, func(event observer.Event) error {
+		o.Emit(event)
+		return nil
+	})
+}
 
-		fmt.Print("> " + message)
-		if strings.TrimSpace(string(text)) == "EXIT" {
-			fmt.Println("HTTP client exiting.")
-			resp.Body.Close()
-			return
+// Watch calls fn with the events of key, or of every key starting with key if
+// prefix is set, from fromIndex on until ctx is done or fn returns an error.
+// Watches aren't retried, a client resumes one by passing the index of the
+// last event it has seen + 1
+func (c *Client) Watch(ctx context.Context, key string, prefix bool, fromIndex int, fn func(database.Event) error) error {
+	parameters := url.Values{"from": {strconv.Itoa(fromIndex)}}
+	if prefix {
+		parameters.Set("prefix", "true")
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint()+"/v1/watch/"+url.PathEscape(key)+"?"+parameters.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.config.Username != "" {
+		httpReq.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		c.setLeader("")
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return newError(resp.StatusCode, body)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event database.Event
+		if err := decoder.Decode(&event); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
 		}
-		resp.Body.Close()
 	}
 }
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, endpoints ...string) *Client {
+	t.Helper()
+	c, err := New(Config{Endpoints: endpoints, RequestTimeout: 100 * time.Millisecond, RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func writeKey(w http.ResponseWriter, value int) {
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, `{"key":"k","value":`+strconv.Itoa(value)+`,"version":1}`)
+}
+
+func TestRedirectToLeader(t *testing.T) {
+	var leaderRequests, followerRequests atomic.Int32
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaderRequests.Add(1)
+		writeKey(w, 1)
+	}))
+	defer leader.Close()
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followerRequests.Add(1)
+		w.Header().Set("Location", leader.URL+r.URL.RequestURI())
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		io.WriteString(w, `{"error":"not leader"}`)
+	}))
+	defer follower.Close()
+
+	c := newTestClient(t, follower.URL)
+	if _, err := c.Set(context.Background(), "k", 1); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if c.Leader() != leader.URL {
+		t.Fatalf("leader = %q, want %q", c.Leader(), leader.URL)
+	}
+	// a known leader gets the requests right away
+	if _, err := c.Get(context.Background(), "k"); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if followerRequests.Load() != 1 || leaderRequests.Load() != 2 {
+		t.Fatalf("follower got %d requests and leader %d, want 1 and 2", followerRequests.Load(), leaderRequests.Load())
+	}
+}
+
+func TestRetryWritesWithinSession(t *testing.T) {
+	var mu sync.Mutex
+	var attempts []string
+	unavailable := 2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, r.Header.Get("X-Client-Id")+"/"+r.Header.Get("X-Client-Seq"))
+		if unavailable > 0 {
+			unavailable--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, `{"error":"no leader is known"}`)
+			return
+		}
+		writeKey(w, 1)
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	if _, err := c.Set(context.Background(), "k", 1); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := c.Delete(context.Background(), "k"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) != 4 {
+		t.Fatalf("attempts = %v, want the write sent 3 times and the delete once", attempts)
+	}
+	if attempts[0] != attempts[1] || attempts[0] != attempts[2] || attempts[0][len(attempts[0])-2:] != "/1" {
+		t.Fatalf("attempts = %v, want every attempt of the write with the first sequence of one session", attempts)
+	}
+	if attempts[3] != attempts[0][:len(attempts[0])-1]+"2" {
+		t.Fatalf("attempts = %v, want the next write with the next sequence of the session", attempts)
+	}
+}
+
+func TestNoRetryOfClusterOperations(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/v1/leader/transfer" {
+			// the answer comes after the client gave up, the transfer started
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, `{"error":"request cancelled before the entry was committed"}`)
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	if err := c.MemberAdd(context.Background(), Member{Name: "n4", PeerAddr: "127.0.0.1:7004"}); !errors.Is(err, ErrNoLeader) {
+		t.Fatalf("MemberAdd = %v, want ErrNoLeader", err)
+	}
+	if err := c.TransferLeadership(context.Background(), "n2"); err == nil {
+		t.Fatal("TransferLeadership succeeded after a timeout")
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("server got %d requests, want each cluster operation sent once", requests.Load())
+	}
+}
+
+func TestConcurrentWritesUseSeparateSessions(t *testing.T) {
+	const writers = 8
+	var mu sync.Mutex
+	inFlight := make(map[string]bool)
+	lastSeq := make(map[string]int)
+	arrived := make(chan struct{}, writers)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientId := r.Header.Get("X-Client-Id")
+		seq, _ := strconv.Atoi(r.Header.Get("X-Client-Seq"))
+		mu.Lock()
+		if inFlight[clientId] || seq <= lastSeq[clientId] {
+			t.Errorf("request %d of session %s sent while another one is in flight or after request %d", seq, clientId, lastSeq[clientId])
+			mu.Unlock()
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		inFlight[clientId] = true
+		lastSeq[clientId] = seq
+		mu.Unlock()
+
+		arrived <- struct{}{}
+		<-release
+		mu.Lock()
+		inFlight[clientId] = false
+		mu.Unlock()
+		writeKey(w, seq)
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	c.config.RequestTimeout = 5 * time.Second
+	var wg sync.WaitGroup
+	for i := 0; i < 2*writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Set(context.Background(), "k", 1); err != nil {
+				t.Errorf("Set: %v", err)
+			}
+		}()
+	}
+	// the first writers are all in flight at once, in sessions of their own
+	for i := 0; i < writers; i++ {
+		<-arrived
+	}
+	close(release)
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(lastSeq) < writers {
+		t.Fatalf("%d sessions for %d concurrent writes", len(lastSeq), writers)
+	}
+}
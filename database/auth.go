@@ -0,0 +1,637 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Users, roles and their permissions live in the replicated store and are
+// changed with AUTH commands, so every node enforces the same policy:
+//
+//	AUTH ENABLE | AUTH DISABLE
+//	AUTH USERADD user password | AUTH PASSWD user password | AUTH USERDEL user
+//	AUTH GRANTROLE user role | AUTH REVOKEROLE user role
+//	AUTH ROLEADD role | AUTH ROLEDEL role
+//	AUTH GRANT role prefix read|write|readwrite | AUTH REVOKE role prefix
+//
+// A permission on the prefix "*" covers every key. The built-in root role may
+// access every key and change the policy, and enabling authentication needs a
+// root user. The leader replaces passwords with salted PBKDF2 hashes and adds
+// the secret tokens are signed with to AUTH ENABLE before the command is logged
+const (
+	RootRole = "root"
+	RootUser = "root"
+
+	// AllKeys is the prefix of a permission on every key
+	AllKeys = "*"
+
+	// TokenTTL is how long a token handed out by Authenticate is valid
+	TokenTTL = 10 * time.Minute
+
+	// passwordIterations is the PBKDF2 cost of a password hash
+	passwordIterations = 100000
+)
+
+const (
+	userPrefix = "a/user/"
+	rolePrefix = "a/role/"
+)
+
+var (
+	ErrAuthFailed       = errors.New("authentication failed")
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Permission grants access to the keys starting with Prefix
+type Permission struct {
+	Prefix string `json:"prefix"`
+	Read   bool   `json:"read"`
+	Write  bool   `json:"write"`
+}
+
+type Role struct {
+	Name        string       `json:"name"`
+	Permissions []Permission `json:"permissions"`
+}
+
+type User struct {
+	Name     string   `json:"name"`
+	Password string   `json:"-"`
+	Roles    []string `json:"roles"`
+}
+
+// storedUser is the form a user is kept in, User leaves out the password hash
+type storedUser struct {
+	Password string   `json:"password"`
+	Roles    []string `json:"roles"`
+}
+
+func (s *store) user(name string) (User, bool) {
+	data, exists := s.engine.Get(userPrefix + name)
+	if !exists {
+		return User{}, false
+	}
+	var u storedUser
+	json.Unmarshal(data, &u)
+	return User{Name: name, Password: u.Password, Roles: u.Roles}, true
+}
+
+func (s *store) setUser(u User) {
+	data, _ := json.Marshal(storedUser{Password: u.Password, Roles: u.Roles})
+	s.engine.Put(userPrefix+u.Name, data)
+}
+
+func (s *store) role(name string) (Role, bool) {
+	data, exists := s.engine.Get(rolePrefix + name)
+	if !exists {
+		return Role{}, false
+	}
+	r := Role{Name: name}
+	json.Unmarshal(data, &r.Permissions)
+	return r, true
+}
+
+func (s *store) setRole(r Role) {
+	data, _ := json.Marshal(r.Permissions)
+	s.engine.Put(rolePrefix+r.Name, data)
+}
+
+// hashPassword returns "pbkdf2-sha256$iterations$salt$hash" of password with a
+// random salt
+func hashPassword(password string) string {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	return passwordHash(passwordIterations, hex.EncodeToString(salt), password)
+}
+
+func passwordHash(iterations int, salt string, password string) string {
+	key := pbkdf2SHA256([]byte(password), []byte(salt), iterations)
+	return "pbkdf2-sha256$" + strconv.Itoa(iterations) + "$" + salt + "$" + hex.EncodeToString(key)
+}
+
+// pbkdf2SHA256 derives a key of the size of a SHA-256 sum from password, the
+// first and only block of PBKDF2 with HMAC-SHA256
+func pbkdf2SHA256(password []byte, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// checkPassword checks password against a PBKDF2 hash
+func checkPassword(hash string, password string) bool {
+	splits := strings.Split(hash, "$")
+	if len(splits) != 4 || splits[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(splits[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	expected := passwordHash(iterations, splits[2], password)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+}
+
+// passwordCache remembers the last password of every user that passed
+// checkPassword, so a client sending basic auth with each request pays for the
+// slow hash once. An entry only matches the stored hash it was checked
+// against, which changes with every PASSWD since it gets a new salt, and holds
+// an HMAC of the password under a key of the process, never the password
+type passwordCache struct {
+	mu       sync.Mutex
+	key      []byte
+	verified map[string]verifiedPassword
+}
+
+type verifiedPassword struct {
+	hash string
+	mac  []byte
+}
+
+func newPasswordCache() *passwordCache {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &passwordCache{key: key, verified: make(map[string]verifiedPassword)}
+}
+
+func (c *passwordCache) mac(password string) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+func (c *passwordCache) check(user string, hash string, password string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, exists := c.verified[user]
+	return exists && v.hash == hash && hmac.Equal(v.mac, c.mac(password))
+}
+
+func (c *passwordCache) add(user string, hash string, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.verified[user] = verifiedPassword{hash: hash, mac: c.mac(password)}
+}
+
+// RedactCommand hides the password of AUTH USERADD/PASSWD and the token secret
+// of AUTH ENABLE, so commands can be logged
+func RedactCommand(command string) string {
+	if strings.HasPrefix(command, "SESSION ") {
+		splits := strings.SplitN(command, " ", 4)
+		if len(splits) == 4 {
+			splits[3] = RedactCommand(splits[3])
+		}
+		return strings.Join(splits, " ")
+	}
+	splits := strings.Split(command, " ")
+	switch {
+	case splits[0] != "AUTH" || len(splits) < 2:
+	case len(splits) > 3 && (splits[1] == "USERADD" || splits[1] == "PASSWD"):
+		splits = append(splits[:3], "[redacted]")
+	case len(splits) > 2 && splits[1] == "ENABLE":
+		splits = append(splits[:2], "[redacted]")
+	}
+	return strings.Join(splits, " ")
+}
+
+// stampAuth hashes the password of AUTH USERADD/PASSWD and adds a new token
+// secret to AUTH ENABLE
+func stampAuth(command string) string {
+	splits := strings.Split(command, " ")
+	switch {
+	case len(splits) == 4 && (splits[1] == "USERADD" || splits[1] == "PASSWD"):
+		splits[3] = hashPassword(splits[3])
+	case len(splits) == 2 && splits[1] == "ENABLE":
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		splits = append(splits, hex.EncodeToString(secret))
+	}
+	return strings.Join(splits, " ")
+}
+
+func validAuthName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " #,|:$")
+}
+
+func validateAuth(command string) error {
+	splits := strings.Split(command, " ")
+	if len(splits) < 2 {
+		return errors.New("need a subcommand for AUTH operation")
+	}
+	args := map[string]int{
+		"ENABLE": 0, "DISABLE": 0,
+		"USERADD": 2, "PASSWD": 2, "USERDEL": 1,
+		"GRANTROLE": 2, "REVOKEROLE": 2,
+		"ROLEADD": 1, "ROLEDEL": 1,
+		"GRANT": 3, "REVOKE": 2,
+	}
+	n, ok := args[splits[1]]
+	if !ok {
+		return errors.New("unknown AUTH subcommand " + splits[1])
+	}
+	if len(splits) != n+2 {
+		return errors.New("need " + strconv.Itoa(n) + " arguments for AUTH " + splits[1])
+	}
+	for i, arg := range splits[2:] {
+		// passwords and prefixes only need to fit in the log
+		if i == 1 && (splits[1] == "USERADD" || splits[1] == "PASSWD" || splits[1] == "GRANT" || splits[1] == "REVOKE") {
+			if arg == "" || strings.ContainsAny(arg, "#,|") {
+				return errors.New("not a valid password or prefix")
+			}
+			continue
+		}
+		if !validAuthName(arg) {
+			return errors.New("not a valid user or role name")
+		}
+	}
+	if splits[1] == "GRANT" && splits[4] != "read" && splits[4] != "write" && splits[4] != "readwrite" {
+		return errors.New("permission must be read, write or readwrite")
+	}
+	return nil
+}
+
+// PerformAuth applies an AUTH command
+func (d *Database) PerformAuth(command string) Result {
+	splits := strings.Split(command, " ")
+	switch splits[1] {
+	case "ENABLE":
+		if d.authEnabled() {
+			return failedResult(StatusConditionFailed, "Authentication is already enabled")
+		}
+		root, exists := d.db.user(RootUser)
+		if !exists || !hasRole(root, RootRole) {
+			return failedResult(StatusConditionFailed, "Authentication needs a root user with the root role")
+		}
+		if len(splits) != 3 {
+			return failedResult(StatusInvalid, "AUTH ENABLE without a token secret")
+		}
+		d.db.setMeta("auth_secret", splits[2])
+		return okResult("Authentication enabled", "", 0, 0)
+	case "DISABLE":
+		d.db.engine.Delete(metaPrefix + "auth_secret")
+		return okResult("Authentication disabled", "", 0, 0)
+	case "USERADD":
+		if _, exists := d.db.user(splits[2]); exists {
+			return failedResult(StatusConditionFailed, "User "+splits[2]+" already exists")
+		}
+		d.db.setUser(User{Name: splits[2], Password: splits[3], Roles: []string{}})
+		return okResult("Added user "+splits[2], "", 0, 0)
+	case "PASSWD":
+		u, exists := d.db.user(splits[2])
+		if !exists {
+			return failedResult(StatusNotFound, "User "+splits[2]+" not found")
+		}
+		u.Password = splits[3]
+		d.db.setUser(u)
+		return okResult("Changed password of user "+splits[2], "", 0, 0)
+	case "USERDEL":
+		if splits[2] == RootUser && d.authEnabled() {
+			return failedResult(StatusConditionFailed, "Can't delete the root user while authentication is enabled")
+		}
+		if !d.db.engine.Delete(userPrefix + splits[2]) {
+			return failedResult(StatusNotFound, "User "+splits[2]+" not found")
+		}
+		return okResult("Deleted user "+splits[2], "", 0, 0)
+	case "GRANTROLE", "REVOKEROLE":
+		u, exists := d.db.user(splits[2])
+		if !exists {
+			return failedResult(StatusNotFound, "User "+splits[2]+" not found")
+		}
+		if _, exists := d.db.role(splits[3]); !exists && splits[3] != RootRole {
+			return failedResult(StatusNotFound, "Role "+splits[3]+" not found")
+		}
+		roles := make([]string, 0, len(u.Roles)+1)
+		for _, role := range u.Roles {
+			if role != splits[3] {
+				roles = append(roles, role)
+			}
+		}
+		if splits[1] == "GRANTROLE" {
+			roles = append(roles, splits[3])
+		} else if splits[2] == RootUser && splits[3] == RootRole && d.authEnabled() {
+			return failedResult(StatusConditionFailed, "Can't revoke the root role of the root user while authentication is enabled")
+		}
+		u.Roles = roles
+		d.db.setUser(u)
+		return okResult("Changed roles of user "+splits[2], "", 0, 0)
+	case "ROLEADD":
+		if _, exists := d.db.role(splits[2]); exists || splits[2] == RootRole {
+			return failedResult(StatusConditionFailed, "Role "+splits[2]+" already exists")
+		}
+		d.db.setRole(Role{Name: splits[2], Permissions: []Permission{}})
+		return okResult("Added role "+splits[2], "", 0, 0)
+	case "ROLEDEL":
+		if !d.db.engine.Delete(rolePrefix + splits[2]) {
+			return failedResult(StatusNotFound, "Role "+splits[2]+" not found")
+		}
+		return okResult("Deleted role "+splits[2], "", 0, 0)
+	case "GRANT", "REVOKE":
+		r, exists := d.db.role(splits[2])
+		if !exists {
+			return failedResult(StatusNotFound, "Role "+splits[2]+" not found")
+		}
+		perms := make([]Permission, 0, len(r.Permissions)+1)
+		for _, perm := range r.Permissions {
+			if perm.Prefix != splits[3] {
+				perms = append(perms, perm)
+			}
+		}
+		if splits[1] == "GRANT" {
+			perms = append(perms, Permission{Prefix: splits[3], Read: strings.Contains(splits[4], "read"), Write: strings.Contains(splits[4], "write")})
+		}
+		r.Permissions = perms
+		d.db.setRole(r)
+		return okResult("Changed permissions of role "+splits[2], "", 0, 0)
+	}
+	return failedResult(StatusInvalid, "invalid AUTH command")
+}
+
+func hasRole(u User, role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Database) authEnabled() bool {
+	_, enabled := d.db.meta("auth_secret")
+	return enabled
+}
+
+// AuthEnabled tells if requests have to be authenticated
+func (d *Database) AuthEnabled() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.authEnabled()
+}
+
+// Authenticate checks the password of a user. Hashing is slow on purpose so
+// it's done without holding mu, and only once for a password that passed
+func (d *Database) Authenticate(name string, password string) error {
+	d.mu.RLock()
+	u, exists := d.db.user(name)
+	d.mu.RUnlock()
+
+	if !exists {
+		return ErrAuthFailed
+	}
+	if d.passwords.check(name, u.Password, password) {
+		return nil
+	}
+	if !checkPassword(u.Password, password) {
+		return ErrAuthFailed
+	}
+	d.passwords.add(name, u.Password, password)
+	return nil
+}
+
+func (d *Database) tokenSignature(payload string) (string, error) {
+	secret, exists := d.db.meta("auth_secret")
+	if !exists {
+		return "", errors.New("authentication is not enabled")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// IssueToken authenticates a user and returns a token valid for TokenTTL. Tokens
+// are signed with the secret in the replicated store so every node accepts them
+func (d *Database) IssueToken(name string, password string, now time.Time) (string, error) {
+	if err := d.Authenticate(name, password); err != nil {
+		return "", err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(name + ":" + strconv.FormatInt(now.Add(TokenTTL).Unix(), 10)))
+	signature, err := d.tokenSignature(payload)
+	if err != nil {
+		return "", err
+	}
+	return payload + "." + signature, nil
+}
+
+// VerifyToken returns the user a token was issued to
+func (d *Database) VerifyToken(token string, now time.Time) (string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	splits := strings.Split(token, ".")
+	if len(splits) != 2 {
+		return "", ErrAuthFailed
+	}
+	signature, err := d.tokenSignature(splits[0])
+	if err != nil || !hmac.Equal([]byte(signature), []byte(splits[1])) {
+		return "", ErrAuthFailed
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(splits[0])
+	if err != nil {
+		return "", ErrAuthFailed
+	}
+	sep := strings.LastIndex(string(payload), ":")
+	expiry, err := strconv.ParseInt(string(payload[sep+1:]), 10, 64)
+	if sep < 0 || err != nil || now.Unix() > expiry {
+		return "", ErrAuthFailed
+	}
+	name := string(payload[:sep])
+	if _, exists := d.db.user(name); !exists {
+		return "", ErrAuthFailed
+	}
+	return name, nil
+}
+
+// covers tells if a permission includes the keys in [start, end), an empty end
+// stands for every key from start on
+func (p Permission) covers(start string, end string) bool {
+	if p.Prefix == AllKeys {
+		return true
+	}
+	if !strings.HasPrefix(start, p.Prefix) || end == "" {
+		return false
+	}
+	return PrefixEnd(p.Prefix) == "" || end <= PrefixEnd(p.Prefix)
+}
+
+// keyRange returns the end of the range holding only key
+func keyRange(key string) string {
+	return key + "\x00"
+}
+
+// allowed tells if user may access the keys in [start, end), it's called with mu held
+func (d *Database) allowed(user string, start string, end string, write bool) bool {
+	if !d.authEnabled() {
+		return true
+	}
+	u, exists := d.db.user(user)
+	if !exists {
+		return false
+	}
+	for _, roleName := range u.Roles {
+		if roleName == RootRole {
+			return true
+		}
+		role, _ := d.db.role(roleName)
+		for _, perm := range role.Permissions {
+			if perm.covers(start, end) && ((write && perm.Write) || (!write && perm.Read)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AuthorizeKey tells if user may read or write key
+func (d *Database) AuthorizeKey(user string, key string, write bool) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.allowed(user, key, keyRange(key), write) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// AuthorizeRange tells if user may read the keys in [start, end), an empty
+// end stands for every key from start on
+func (d *Database) AuthorizeRange(user string, start string, end string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.allowed(user, start, end, false) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// isRoot tells if user has the root role, it's called with mu held
+func (d *Database) isRoot(user string) bool {
+	if !d.authEnabled() {
+		return true
+	}
+	u, _ := d.db.user(user)
+	return hasRole(u, RootRole)
+}
+
+// AuthorizeCommand tells if user may have a command applied. Key operations
+// need the permission on their keys, and both permissions if they answer with
+// the value of the key, AUTH, CLUSTER, COMPACT, EXPIRE and MEMBER need the root role
+func (d *Database) AuthorizeCommand(user string, command string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.authorizeCommand(user, command)
+}
+
+func (d *Database) authorizeCommand(user string, command string) error {
+	if !d.authEnabled() {
+		return nil
+	}
+	// the permission is checked on the keys the command is applied to, which
+	// are only known for a valid command
+	if err := d.ValidateCommand(command); err != nil {
+		return err
+	}
+	splits := strings.Split(command, " ")
+	allowed := true
+	switch splits[0] {
+	case "SESSION":
+		return d.authorizeCommand(user, strings.SplitN(command, " ", 4)[3])
+	case "AUTH", "CLUSTER", "COMPACT", "EXPIRE", "MEMBER":
+		allowed = d.isRoot(user)
+	case "GET":
+		allowed = d.allowed(user, splits[1], keyRange(splits[1]), false)
+	case "CAS", "SETNX", "DELIFEQ", "INCR", "DECR", "GETSET":
+		// the result holds the current or the conflicting value of the key
+		allowed = d.allowed(user, splits[1], keyRange(splits[1]), false) && d.allowed(user, splits[1], keyRange(splits[1]), true)
+	case "TXN":
+		txn, err := decodeTxnCommand(command)
+		if err != nil {
+			return err
+		}
+		for _, c := range txn.Compare {
+			allowed = allowed && d.allowed(user, c.Key, keyRange(c.Key), false)
+		}
+		for _, ops := range [][]TxnOp{txn.Success, txn.Failure} {
+			for _, op := range ops {
+				allowed = allowed && d.allowed(user, op.Key, keyRange(op.Key), true)
+			}
+		}
+	default:
+		allowed = len(splits) > 1 && d.allowed(user, splits[1], keyRange(splits[1]), true)
+	}
+	if !allowed {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// Users returns every user with its roles
+func (d *Database) Users() []User {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	users := make([]User, 0)
+	d.db.engine.Ascend(userPrefix, func(key string, value []byte) bool {
+		if !strings.HasPrefix(key, userPrefix) {
+			return false
+		}
+		u, _ := d.db.user(strings.TrimPrefix(key, userPrefix))
+		users = append(users, u)
+		return true
+	})
+	return users
+}
+
+// Roles returns every role with its permissions
+func (d *Database) Roles() []Role {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	roles := []Role{{Name: RootRole, Permissions: []Permission{{Prefix: AllKeys, Read: true, Write: true}}}}
+	d.db.engine.Ascend(rolePrefix, func(key string, value []byte) bool {
+		if !strings.HasPrefix(key, rolePrefix) {
+			return false
+		}
+		r, _ := d.db.role(strings.TrimPrefix(key, rolePrefix))
+		roles = append(roles, r)
+		return true
+	})
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles
+}
+
+// AuthorizeRoot tells if user has the root role, which manages users and roles
+func (d *Database) AuthorizeRoot(user string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.isRoot(user) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// applyStamped applies commands as consecutive log entries the way the leader
+// logs them, with passwords hashed and AUTH ENABLE given its secret
+func applyStamped(t *testing.T, d *Database, commands ...string) {
+	t.Helper()
+	for _, command := range commands {
+		if err := d.ValidateCommand(command); err != nil {
+			t.Fatalf("ValidateCommand(%q): %v", command, err)
+		}
+		if result := d.PerformDbOperations(d.AppliedIndex()+1, StampCommand(command, time.Now())); result.Status != StatusOK {
+			t.Fatalf("%s: %s", RedactCommand(command), result.Message)
+		}
+	}
+}
+
+// newAuthDatabase returns a database with authentication enabled and the users
+// reader, writer and admin, who may read the keys starting with "r/", write
+// the keys starting with "w/" and access every key
+func newAuthDatabase(t *testing.T) *Database {
+	t.Helper()
+	d := newTestDatabase(t)
+	applyStamped(t, d,
+		"AUTH USERADD root rootpw", "AUTH GRANTROLE root root",
+		"AUTH ROLEADD readers", "AUTH GRANT readers r/ read",
+		"AUTH ROLEADD writers", "AUTH GRANT writers w/ write",
+		"AUTH ROLEADD admins", "AUTH GRANT admins * readwrite",
+		"AUTH USERADD reader pw", "AUTH GRANTROLE reader readers",
+		"AUTH USERADD writer pw", "AUTH GRANTROLE writer writers",
+		"AUTH USERADD admin pw", "AUTH GRANTROLE admin admins",
+		"AUTH ENABLE",
+	)
+	return d
+}
+
+func TestAuthorizeCommand(t *testing.T) {
+	d := newAuthDatabase(t)
+	txn := func(compareKey string, setKey string) string {
+		command, err := (&Txn{
+			Compare: []Compare{{Key: compareKey, Target: "exists", Op: "=", Value: 1}},
+			Success: []TxnOp{{Op: "SET", Key: setKey, Value: 1}},
+		}).Command()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return command
+	}
+	tests := []struct {
+		user    string
+		command string
+		ok      bool
+	}{
+		{"reader", "GET r/a", true},
+		{"reader", "GET w/a", false},
+		{"reader", "SET r/a 1", false},
+		{"writer", "SET w/a 1", true},
+		{"writer", "DELETE w/a", true},
+		{"writer", "GET w/a", false},
+		{"writer", "SET r/a 1", false},
+		{"writer", "SET w 1", false},
+		// writes answering with the value of the key need the read permission too
+		{"writer", "CAS w/a 1 2", false},
+		{"writer", "SETNX w/a 1", false},
+		{"writer", "DELIFEQ w/a 1", false},
+		{"writer", "INCR w/a", false},
+		{"writer", "DECR w/a 2", false},
+		{"writer", "GETSET w/a 1", false},
+		{"admin", "CAS w/a 1 2", true},
+		{"admin", "INCR any", true},
+		{"writer", "SESSION c1 1 SET w/a 1", true},
+		{"writer", "SESSION c1 1 INCR w/a", false},
+		{"writer", txn("w/a", "w/b"), false},
+		{"admin", txn("r/a", "w/b"), true},
+		{"admin", "COMPACT 1", false},
+		{"admin", "AUTH USERADD eve pw", false},
+		{"root", "AUTH USERADD eve pw", true},
+		{"root", "COMPACT 1", true},
+		{"nobody", "GET r/a", false},
+		{"reader", "GET r/a\nSET w/a 1", false},
+	}
+	for _, tt := range tests {
+		if err := d.AuthorizeCommand(tt.user, tt.command); (err == nil) != tt.ok {
+			t.Errorf("AuthorizeCommand(%s, %q) = %v, want ok %v", tt.user, tt.command, err, tt.ok)
+		}
+	}
+}
+
+func TestAuthorizeRange(t *testing.T) {
+	d := newAuthDatabase(t)
+	tests := []struct {
+		user  string
+		start string
+		end   string
+		ok    bool
+	}{
+		{"reader", "r/", PrefixEnd("r/"), true},
+		{"reader", "r/a", "r/b", true},
+		{"reader", "r/a", "", false},
+		{"reader", "r", PrefixEnd("r"), false},
+		{"reader", "r/", "s", false},
+		{"reader", "", "", false},
+		{"writer", "w/", PrefixEnd("w/"), false},
+		{"admin", "", "", true},
+		{"root", "", "", true},
+	}
+	for _, tt := range tests {
+		if err := d.AuthorizeRange(tt.user, tt.start, tt.end); (err == nil) != tt.ok {
+			t.Errorf("AuthorizeRange(%s, %q, %q) = %v, want ok %v", tt.user, tt.start, tt.end, err, tt.ok)
+		}
+	}
+}
+
+func TestAuthDisabled(t *testing.T) {
+	d := newAuthDatabase(t)
+	applyStamped(t, d, "AUTH DISABLE")
+	if d.AuthEnabled() {
+		t.Fatal("authentication still enabled")
+	}
+	for _, command := range []string{"GET w/a", "INCR r/a", "AUTH USERADD eve pw", "COMPACT 1"} {
+		if err := d.AuthorizeCommand("", command); err != nil {
+			t.Errorf("AuthorizeCommand(%q) with authentication disabled = %v", command, err)
+		}
+	}
+	if err := d.AuthorizeRange("", "", ""); err != nil {
+		t.Errorf("AuthorizeRange of every key with authentication disabled = %v", err)
+	}
+
+	// enabling authentication needs a root user
+	d = newTestDatabase(t)
+	if result := d.PerformDbOperations(1, StampCommand("AUTH ENABLE", time.Now())); result.Status != StatusConditionFailed {
+		t.Fatalf("AUTH ENABLE without a root user = %+v", result)
+	}
+	if err := d.AuthorizeCommand("nobody", "AUTH USERADD eve pw"); err != nil {
+		t.Fatalf("AuthorizeCommand while authentication is disabled = %v", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	d := newAuthDatabase(t)
+	if err := d.Authenticate("reader", "pw"); err != nil {
+		t.Fatalf("Authenticate with the password: %v", err)
+	}
+	// the second check is answered by the cache of verified passwords
+	if err := d.Authenticate("reader", "pw"); err != nil {
+		t.Fatalf("Authenticate again: %v", err)
+	}
+	if err := d.Authenticate("reader", "other"); err != ErrAuthFailed {
+		t.Fatalf("Authenticate with another password = %v, want ErrAuthFailed", err)
+	}
+	applyStamped(t, d, "AUTH PASSWD reader newpw")
+	if err := d.Authenticate("reader", "pw"); err != ErrAuthFailed {
+		t.Fatalf("Authenticate with the old password = %v, want ErrAuthFailed", err)
+	}
+	if err := d.Authenticate("reader", "newpw"); err != nil {
+		t.Fatalf("Authenticate with the new password: %v", err)
+	}
+	applyStamped(t, d, "AUTH USERDEL reader")
+	if err := d.Authenticate("reader", "newpw"); err != ErrAuthFailed {
+		t.Fatalf("Authenticate a deleted user = %v, want ErrAuthFailed", err)
+	}
+	if checkPassword("sha256$salt$00", "pw") {
+		t.Fatal("checkPassword accepted a hash that isn't PBKDF2")
+	}
+}
@@ -2,41 +2,105 @@ package database
 
 import (
 	"errors"
+	"math"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
+	"github.com/ssergomol/raft/persist"
 	"github.com/ssergomol/raft/utils"
 )
 
+// entry is a stored value together with its version, the log index of the
+// last write to the key, and its expiration time in unix milliseconds (0 if
+// the key doesn't expire)
+type entry struct {
+	value    int
+	version  int
+	expireAt int64
+}
+
+// Database is the replicated state machine. Keys are kept ordered in a storage
+// engine, writes happen through PerformDbOperations while reads may run
+// concurrently from the HTTP handlers, so both go through mu
 type Database struct {
-	db map[string]int
+	mu sync.RWMutex
+	db *store
+
+	compactRevision int
+
+	watchers       map[*Watcher]struct{}
+	history        []Event
+	compactedIndex int
+
+	passwords *passwordCache
 }
 
-func NewDatabase() (db *Database, err error) {
-	keyValueStore := make(map[string]int)
-	db = &Database{db: keyValueStore}
+func NewDatabase(engine Engine) (db *Database, err error) {
+	keyValueStore := &store{engine: engine}
+	db = &Database{
+		db:        keyValueStore,
+		watchers:  make(map[*Watcher]struct{}),
+		passwords: newPasswordCache(),
+		// the entries the engine already holds aren't applied again, so their
+		// events aren't in the history and watches can't resume from them
+		compactedIndex: engine.AppliedIndex(),
+	}
+	if rev, exists := keyValueStore.meta("compact_revision"); exists {
+		db.compactRevision, err = strconv.Atoi(rev)
+		if err != nil {
+			return nil, errors.New("invalid compaction revision in storage engine")
+		}
+	}
 	return db, nil
 }
 
-func (d *Database) setKey(key string, value int) error {
-	d.db[key] = value
+// AppliedIndex returns the index of the last log entry applied to the storage engine
+func (d *Database) AppliedIndex() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.db.engine.AppliedIndex()
+}
+
+func (d *Database) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.db.engine.Close()
+}
+
+func (d *Database) setKey(key string, value int, index int) error {
+	d.db.set(key, entry{value: value, version: index})
+	d.recordRevision(key, Revision{Index: index, Value: value})
+	d.notify(Event{Type: "SET", Key: key, Value: value, Index: index})
 	return nil
 }
 
+var ErrKeyNotFound = errors.New("key not found")
+
 func (d *Database) getKey(key string) (int, error) {
-	val, exists := d.db[key]
+	e, exists := d.db.get(key)
 	if !exists {
-		return -1, errors.New("key not found")
+		return -1, ErrKeyNotFound
 	}
-	return val, nil
+	return e.value, nil
 }
 
-func (d *Database) deleteKey(key string) error {
-	_, exists := d.db[key]
-	if !exists {
+// getVersion returns the log index of the last write to key, 0 if the key doesn't exist
+func (d *Database) getVersion(key string) int {
+	e, _ := d.db.get(key)
+	return e.version
+}
+
+func (d *Database) deleteKey(key string, index int) error {
+	if !d.db.delete(key) {
 		return errors.New("key not found")
 	}
-	delete(d.db, key)
+	d.recordRevision(key, Revision{Index: index, Deleted: true})
+	d.notify(Event{Type: "DELETE", Key: key, Index: index})
 	return nil
 }
 
@@ -85,104 +149,364 @@ func (d *Database) ValidateDelete(cmd string) error {
 }
 
 func (d *Database) PerformGet(key string) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	var res string
 	val, err := d.getKey(key)
+	if err == nil && d.isExpired(key, time.Now()) {
+		err = errors.New("key expired")
+	}
 	if err != nil {
 		res = "Key not found error"
 	} else {
-		res = "Value for key (" + key + ") is: " + strconv.Itoa(val)
+		res = "Value for key (" + key + ") is: " + strconv.Itoa(val) + ", version: " + strconv.Itoa(d.getVersion(key))
 	}
 
 	return res
 }
 
-func (d *Database) PerformSet(cmd string) string {
+// Lookup returns key as of the log entry at index, or its current state if index is 0
+func (d *Database) Lookup(key string, index int) (KeyValue, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if index > 0 {
+		val, err := d.getKeyAt(key, index)
+		if err != nil {
+			return KeyValue{}, err
+		}
+		return KeyValue{Key: key, Value: val}, nil
+	}
+
+	now := time.Now()
+	e, exists := d.db.get(key)
+	if !exists || e.expired(now) {
+		return KeyValue{}, ErrKeyNotFound
+	}
+	return newKeyValue(key, e, now), nil
+}
+
+func (d *Database) PerformSet(index int, cmd string) Result {
 	cmdSplits := strings.Split(cmd, " ")
-	var res string
 	key := cmdSplits[1]
 	val, _ := strconv.Atoi(cmdSplits[2])
-	if err := d.setKey(key, val); err != nil {
-		res = "Error inserting key in DB"
-		return res
+	if err := d.setKey(key, val, index); err != nil {
+		return failedResult(StatusError, "Error inserting key in DB")
+	}
+	if len(cmdSplits) == 5 {
+		expireAt, _ := strconv.ParseInt(cmdSplits[4], 10, 64)
+		d.setExpiry(key, expireAt)
 	}
 
-	res = "Key set successfully"
-	return res
+	return okResult("Key set successfully", key, val, index)
 }
 
-func (d *Database) PerformDelete(key string) string {
-	var res string
+func (d *Database) PerformDelete(index int, key string) Result {
+	if err := d.deleteKey(key, index); err != nil {
+		return failedResult(StatusNotFound, "Key not found")
+	}
+
+	return okResult("Key deleted successfully", key, 0, index)
+}
+
+// conflict reports a condition that didn't hold together with the current state of key
+func (d *Database) conflict(message string, key string) Result {
+	e, _ := d.db.get(key)
+	return Result{Status: StatusConditionFailed, Message: message, Key: key, Value: e.value, Version: e.version}
+}
 
-	if err := d.deleteKey(key); err != nil {
-		res = "Key not found"
-		return res
+// PerformCas sets key to the new value only if its current value equals the expected one
+func (d *Database) PerformCas(index int, key string, expected int, value int) Result {
+	current, err := d.getKey(key)
+	if err != nil {
+		return failedResult(StatusNotFound, "CAS failed, key not found")
+	}
+	if current != expected {
+		return d.conflict("CAS failed, current value is: "+strconv.Itoa(current), key)
 	}
+	if err := d.setKey(key, value, index); err != nil {
+		return failedResult(StatusError, "Error inserting key in DB")
+	}
+	return okResult("CAS succeeded", key, value, index)
+}
 
-	res = "Key deleted successfully"
-	return res
+// PerformSetNX sets key only if it doesn't exist yet
+func (d *Database) PerformSetNX(index int, key string, value int) Result {
+	if _, err := d.getKey(key); err == nil {
+		return d.conflict("SETNX failed, key already exists", key)
+	}
+	if err := d.setKey(key, value, index); err != nil {
+		return failedResult(StatusError, "Error inserting key in DB")
+	}
+	return okResult("SETNX succeeded", key, value, index)
+}
+
+// PerformDelIfEq deletes key only if its current value equals the expected one
+func (d *Database) PerformDelIfEq(index int, key string, expected int) Result {
+	current, err := d.getKey(key)
+	if err != nil {
+		return failedResult(StatusNotFound, "DELIFEQ failed, key not found")
+	}
+	if current != expected {
+		return d.conflict("DELIFEQ failed, current value is: "+strconv.Itoa(current), key)
+	}
+	if err := d.deleteKey(key, index); err != nil {
+		return failedResult(StatusNotFound, "Key not found")
+	}
+	return okResult("DELIFEQ succeeded", key, 0, index)
+}
+
+// PerformIncr adds delta to the value of key, treating a missing key as 0. If
+// the result doesn't fit in an int the key is left unchanged and an overflow
+// error is returned instead
+func (d *Database) PerformIncr(index int, key string, delta int) Result {
+	current, err := d.getKey(key)
+	if err != nil {
+		current = 0
+	}
+	if (delta > 0 && current > math.MaxInt-delta) || (delta < 0 && current < math.MinInt-delta) {
+		return failedResult(StatusInvalid, "Value overflow error, key ("+key+") is unchanged")
+	}
+	e, _ := d.db.get(key)
+	expireAt := e.expireAt
+	if err := d.setKey(key, current+delta, index); err != nil {
+		return failedResult(StatusError, "Error inserting key in DB")
+	}
+	d.setExpiry(key, expireAt)
+	return okResult("Value for key ("+key+") is: "+strconv.Itoa(current+delta), key, current+delta, index)
+}
+
+// PerformDecr subtracts delta from the value of key, see PerformIncr
+func (d *Database) PerformDecr(index int, key string, delta int) Result {
+	if delta == math.MinInt {
+		return failedResult(StatusInvalid, "Value overflow error, key ("+key+") is unchanged")
+	}
+	return d.PerformIncr(index, key, -delta)
+}
+
+// PerformGetSet sets key to value and returns the value it had before
+func (d *Database) PerformGetSet(index int, key string, value int) Result {
+	old, getErr := d.getKey(key)
+	if err := d.setKey(key, value, index); err != nil {
+		return failedResult(StatusError, "Error inserting key in DB")
+	}
+	if getErr != nil {
+		return okResult("Key set successfully, it had no previous value", key, value, index)
+	}
+	return okResult("Previous value for key ("+key+") was: "+strconv.Itoa(old), key, value, index)
+}
+
+// keyOperations are the operations taking a key as their first argument
+var keyOperations = map[string]bool{
+	"GET": true, "SET": true, "DELETE": true, "EXPIRE": true, "CAS": true,
+	"SETNX": true, "DELIFEQ": true, "INCR": true, "DECR": true, "GETSET": true,
+}
+
+// ValidKey tells if key can be stored. Commands are split on spaces and written
+// one per line to the log, where '#', ',' and '|' separate fields, so a key
+// can't hold any of them nor a control character
+func ValidKey(key string) bool {
+	if key == "" || strings.ContainsAny(key, " #,|") {
+		return false
+	}
+	for _, r := range key {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
 }
 
 // ValidateCommand performs validation for commands received from client for DB operations
 func (d *Database) ValidateCommand(command string) error {
+	if strings.IndexFunc(command, unicode.IsControl) >= 0 {
+		return errors.New("control characters aren't allowed in commands")
+	}
 	splits := strings.Split(command, " ")
 	operation := splits[0]
-	if operation == "GET" || operation == "DELETE" {
+	if keyOperations[operation] && len(splits) > 1 && !ValidKey(splits[1]) {
+		return errors.New("not a valid key")
+	}
+	if operation == "SESSION" {
+		if err := validateSession(command); err != nil {
+			return err
+		}
+		return d.ValidateCommand(strings.SplitN(command, " ", 4)[3])
+	} else if operation == "GET" || operation == "DELETE" {
 		if len(splits) != 2 {
 			return errors.New("need a key for GET/DELETE operation")
 		}
 	} else if operation == "SET" {
-		if len(splits) != 3 {
+		if len(splits) != 3 && len(splits) != 5 {
 			return errors.New("need a key and a value for SET operation")
 		}
 		_, err := strconv.Atoi(splits[2])
 		if err != nil {
 			return errors.New("not a valid integer value")
 		}
+		if len(splits) == 5 {
+			return validateExpiry(splits[3], splits[4])
+		}
+	} else if operation == "COMPACT" {
+		if len(splits) != 2 {
+			return errors.New("need a revision for COMPACT operation")
+		}
+		if rev, err := strconv.Atoi(splits[1]); err != nil || rev <= 0 {
+			return errors.New("not a valid revision")
+		}
+	} else if operation == "EXPIRE" {
+		if len(splits) != 3 {
+			return errors.New("need a key and an expiration time for EXPIRE operation")
+		}
+		if _, err := strconv.ParseInt(splits[2], 10, 64); err != nil {
+			return errors.New("not a valid expiration time")
+		}
+	} else if operation == "CAS" {
+		if len(splits) != 4 {
+			return errors.New("need a key, an expected and a new value for CAS operation")
+		}
+		if !isInteger(splits[2]) || !isInteger(splits[3]) {
+			return errors.New("not a valid integer value")
+		}
+	} else if operation == "SETNX" {
+		if len(splits) != 3 {
+			return errors.New("need a key and a value for SETNX operation")
+		}
+		if !isInteger(splits[2]) {
+			return errors.New("not a valid integer value")
+		}
+	} else if operation == "DELIFEQ" {
+		if len(splits) != 3 {
+			return errors.New("need a key and an expected value for DELIFEQ operation")
+		}
+		if !isInteger(splits[2]) {
+			return errors.New("not a valid integer value")
+		}
+	} else if operation == "INCR" || operation == "DECR" {
+		if len(splits) != 2 && len(splits) != 3 {
+			return errors.New("need a key and an optional delta for INCR/DECR operation")
+		}
+		if len(splits) == 3 && !isInteger(splits[2]) {
+			return errors.New("not a valid integer value")
+		}
+	} else if operation == "TXN" {
+		if len(splits) != 2 {
+			return errors.New("need an encoded transaction for TXN operation")
+		}
+		if _, err := decodeTxnCommand(command); err != nil {
+			return err
+		}
+	} else if operation == "AUTH" {
+		return validateAuth(command)
+	} else if operation == "MEMBER" {
+		return validateMember(command)
+	} else if operation == "CLUSTER" {
+		return validateCluster(command)
+	} else if operation == "GETSET" {
+		if len(splits) != 3 {
+			return errors.New("need a key and a value for GETSET operation")
+		}
+		if !isInteger(splits[2]) {
+			return errors.New("not a valid integer value")
+		}
 	} else {
 		return errors.New("invalid command")
 	}
 	return nil
 }
 
-// // PerformOperations updates the storage by processing given operation
-func (d *Database) PerformDbOperations(command string) string {
+func isInteger(value string) bool {
+	_, err := strconv.Atoi(value)
+	return err == nil
+}
+
+// PerformDbOperations updates the storage by processing given operation, index is
+// the position of the command in the replicated log and becomes the version of written keys.
+// Entries the storage engine already holds are skipped, and the writes of an entry are
+// committed together with its index. The state machine can't make progress without a
+// working storage so a failed commit panics
+func (d *Database) PerformDbOperations(index int, command string) Result {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if index <= d.db.engine.AppliedIndex() {
+		return Result{}
+	}
+	response := d.apply(index, command)
+	if err := d.db.engine.Commit(index); err != nil {
+		panic("failed to commit log entry " + strconv.Itoa(index) + " to the storage engine: " + err.Error())
+	}
+	return response
+}
+
+func (d *Database) apply(index int, command string) Result {
 	splits := strings.Split(command, " ")
 	operation := splits[0]
-	var response string = ""
+	var response Result
 	if operation == "GET" {
 		key := splits[1]
 		val, err := d.getKey(key)
 		if err != nil {
-			response = "Key not found error"
+			response = failedResult(StatusNotFound, "Key not found error")
 		} else {
-			response = "Value for key (" + key + ") is: " + strconv.Itoa(val)
+			response = okResult("Value for key ("+key+") is: "+strconv.Itoa(val), key, val, d.getVersion(key))
 		}
 	} else if operation == "SET" {
-		key := splits[1]
+		response = d.PerformSet(index, command)
+	} else if operation == "DELETE" {
+		response = d.PerformDelete(index, splits[1])
+	} else if operation == "CAS" {
+		expected, _ := strconv.Atoi(splits[2])
+		val, _ := strconv.Atoi(splits[3])
+		response = d.PerformCas(index, splits[1], expected, val)
+	} else if operation == "SETNX" {
 		val, _ := strconv.Atoi(splits[2])
-		if response == "" {
-			if err := d.setKey(key, val); err != nil {
-				response = "Error inserting key in DB"
-			}
+		response = d.PerformSetNX(index, splits[1], val)
+	} else if operation == "DELIFEQ" {
+		expected, _ := strconv.Atoi(splits[2])
+		response = d.PerformDelIfEq(index, splits[1], expected)
+	} else if operation == "INCR" || operation == "DECR" {
+		delta := 1
+		if len(splits) == 3 {
+			delta, _ = strconv.Atoi(splits[2])
 		}
-		if response == "" {
-			response = "Key set successfully"
-		}
-	} else if operation == "DELETE" {
-		key := splits[1]
-		if err := d.deleteKey(key); err != nil {
-			response = "Key not found"
+		if operation == "INCR" {
+			response = d.PerformIncr(index, splits[1], delta)
+		} else {
+			response = d.PerformDecr(index, splits[1], delta)
 		}
-		if response == "" {
-			response = "Key deleted successfully"
+	} else if operation == "GETSET" {
+		val, _ := strconv.Atoi(splits[2])
+		response = d.PerformGetSet(index, splits[1], val)
+	} else if operation == "SESSION" {
+		response = d.applySession(index, command)
+	} else if operation == "COMPACT" {
+		rev, _ := strconv.Atoi(splits[1])
+		response = d.PerformCompact(rev)
+	} else if operation == "EXPIRE" {
+		expireAt, _ := strconv.ParseInt(splits[2], 10, 64)
+		response = d.PerformExpire(index, splits[1], expireAt)
+	} else if operation == "TXN" {
+		txn, err := decodeTxnCommand(command)
+		if err != nil {
+			response = failedResult(StatusInvalid, err.Error())
+		} else {
+			response = d.PerformTxn(index, txn)
 		}
+	} else if operation == "AUTH" {
+		response = d.PerformAuth(command)
+	} else if operation == "MEMBER" {
+		response = d.PerformMember(command)
+	} else if operation == "CLUSTER" {
+		response = d.PerformCluster(command)
 	}
 	return response
 }
 
 // LogDbCommand logs the database command to log file
 func (d *Database) LogCommand(command string, serverName string) error {
-	fileName := serverName + ".txt"
+	fileName := persist.DataPath(persist.LogFileName)
 	var err = utils.CreateFileIfNotExists(fileName)
 	if err != nil {
 		return err
@@ -196,7 +520,7 @@ func (d *Database) LogCommand(command string, serverName string) error {
 
 func (d *Database) RebuildLogIfExists(serverName string) []string {
 	logs := make([]string, 0)
-	fileName := serverName + ".txt"
+	fileName := persist.DataPath(persist.LogFileName)
 	utils.CreateFileIfNotExists(fileName)
 	lines, _ := utils.ReadFile(fileName)
 	for _, line := range lines {
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	d, err := NewDatabase(NewMemoryEngine())
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	return d
+}
+
+// apply applies commands as consecutive log entries and returns the result of
+// the last one
+func apply(t *testing.T, d *Database, commands ...string) Result {
+	t.Helper()
+	var result Result
+	for _, command := range commands {
+		if err := d.ValidateCommand(command); err != nil {
+			t.Fatalf("ValidateCommand(%q): %v", command, err)
+		}
+		result = d.PerformDbOperations(d.AppliedIndex()+1, command)
+	}
+	return result
+}
+
+func TestIncrDecrOverflow(t *testing.T) {
+	maxInt := strconv.Itoa(math.MaxInt)
+	minInt := strconv.Itoa(math.MinInt)
+	tests := []struct {
+		name     string
+		commands []string
+		status   Status
+		value    int
+	}{
+		{"incr missing key", []string{"INCR k"}, StatusOK, 1},
+		{"decr missing key", []string{"DECR k"}, StatusOK, -1},
+		{"incr missing key by delta", []string{"INCR k 5"}, StatusOK, 5},
+		{"decr missing key by min int", []string{"DECR k " + minInt}, StatusInvalid, 0},
+		{"incr to max int", []string{"SET k " + strconv.Itoa(math.MaxInt-1), "INCR k"}, StatusOK, math.MaxInt},
+		{"incr past max int", []string{"SET k " + maxInt, "INCR k"}, StatusInvalid, math.MaxInt},
+		{"incr by max int past max int", []string{"SET k 1", "INCR k " + maxInt}, StatusInvalid, 1},
+		{"decr to min int", []string{"SET k " + strconv.Itoa(math.MinInt+1), "DECR k"}, StatusOK, math.MinInt},
+		{"decr past min int", []string{"SET k " + minInt, "DECR k"}, StatusInvalid, math.MinInt},
+		{"incr min int by negative delta", []string{"SET k " + minInt, "INCR k -1"}, StatusInvalid, math.MinInt},
+		{"decr by min int", []string{"SET k 0", "DECR k " + minInt}, StatusInvalid, 0},
+		{"decr max int by negative delta", []string{"SET k " + maxInt, "DECR k -1"}, StatusInvalid, math.MaxInt},
+		{"incr min int by max int", []string{"SET k " + minInt, "INCR k " + maxInt}, StatusOK, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDatabase(t)
+			result := apply(t, d, tt.commands...)
+			if result.Status != tt.status {
+				t.Fatalf("status = %v (%s), want %v", result.Status, result.Message, tt.status)
+			}
+			value, err := d.getKey("k")
+			if tt.status != StatusOK && len(tt.commands) == 1 {
+				// a failed command on a missing key must not create it
+				if err == nil {
+					t.Fatalf("key was created with value %d", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("key not found: %v", err)
+			}
+			if value != tt.value {
+				t.Fatalf("value = %d, want %d", value, tt.value)
+			}
+		})
+	}
+}
+
+func TestSetExpiryBounds(t *testing.T) {
+	d := newTestDatabase(t)
+	maxSeconds := strconv.FormatInt(math.MaxInt64/int64(time.Second), 10)
+	for _, command := range []string{"SET t 1 EX 9223372036854775807", "SET t 1 EX " + maxSeconds + "1", "SET t 1 EX 0"} {
+		if err := d.ValidateCommand(command); err == nil {
+			t.Errorf("ValidateCommand(%q) accepted the expiration time", command)
+		}
+	}
+
+	command := "SET t 1 EX " + maxSeconds
+	now := time.Now()
+	apply(t, d, StampCommand(command, now))
+	if ttl := d.PerformTTL("t"); ttl == "TTL for key (t) is: -2" {
+		t.Fatalf("%s expired right away", command)
+	}
+}
+
+func TestValidateCommandRejectsBadKeys(t *testing.T) {
+	d := newTestDatabase(t)
+	txn := func(key string) string {
+		command, err := (&Txn{Success: []TxnOp{{Op: "SET", Key: key, Value: 1}}}).Command()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return command
+	}
+	tests := []struct {
+		command string
+		ok      bool
+	}{
+		{"SET a/b 1", true},
+		{"SET a\nb 1", false},
+		{"SET a\rb 1", false},
+		{"GET a\tb", false},
+		{"INCR a\x00b", false},
+		{"SET a#b 1", false},
+		{"CAS a,b 1 2", false},
+		{"DELETE a|b", false},
+		{"SET  1", false},
+		{"SESSION c1 1 SET a\nb 1", false},
+		{"AUTH USERADD u pass\nword", false},
+		{txn("a/b"), true},
+		{txn("a\nb"), false},
+		{txn("a b"), false},
+	}
+	for _, tt := range tests {
+		if err := d.ValidateCommand(tt.command); (err == nil) != tt.ok {
+			t.Errorf("ValidateCommand(%q) = %v, want ok %v", tt.command, err, tt.ok)
+		}
+	}
+}
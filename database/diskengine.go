@@ -0,0 +1,204 @@
+package database
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// diskRewriteThreshold is the number of committed batches after which the data
+// file is rewritten with only the live keys
+const diskRewriteThreshold = 10000
+
+// diskOp is a single write of a committed batch
+type diskOp struct {
+	Key    string `json:"k"`
+	Value  []byte `json:"v,omitempty"`
+	Delete bool   `json:"d,omitempty"`
+}
+
+// diskBatch is a record of the data file: the writes of one or more log
+// entries together with the index of the last of them
+type diskBatch struct {
+	AppliedIndex int      `json:"applied_index"`
+	Ops          []diskOp `json:"ops"`
+}
+
+// diskEngine is an append-only, log-structured engine. Every commit appends one
+// length-prefixed, checksummed record to the data file and syncs it, so the
+// writes of a log entry and the applied index land on disk atomically. A torn
+// record at the end of the file, left by a crash in the middle of a commit, is
+// discarded on open. The data is served from an in-memory skip list that is
+// rebuilt from the file when the engine is opened
+type diskEngine struct {
+	path         string
+	file         *os.File
+	list         *skipList
+	pending      []diskOp
+	appliedIndex int
+	records      int
+}
+
+// OpenDiskEngine opens the data file at path, creating it if needed
+func OpenDiskEngine(path string) (Engine, error) {
+	e := &diskEngine{path: path, list: newSkipList()}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	e.file = file
+
+	validLength, err := e.load()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(validLength); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(validLength, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// load replays the records of the data file and returns the length of its valid part
+func (e *diskEngine) load() (int64, error) {
+	reader := bufio.NewReader(e.file)
+	var offset int64
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return offset, nil
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		checksum := binary.BigEndian.Uint32(header[4:])
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return offset, nil
+		}
+		if crc32.ChecksumIEEE(payload) != checksum {
+			return offset, nil
+		}
+		var batch diskBatch
+		if err := json.Unmarshal(payload, &batch); err != nil {
+			return offset, errors.New("corrupted record in " + e.path)
+		}
+		e.apply(batch)
+		e.records++
+		offset += int64(len(header)) + int64(length)
+	}
+}
+
+func (e *diskEngine) apply(batch diskBatch) {
+	for _, op := range batch.Ops {
+		if op.Delete {
+			e.list.delete(op.Key)
+		} else {
+			e.list.set(op.Key, op.Value)
+		}
+	}
+	e.appliedIndex = batch.AppliedIndex
+}
+
+func (e *diskEngine) Get(key string) ([]byte, bool) {
+	return e.list.get(key)
+}
+
+func (e *diskEngine) Put(key string, value []byte) {
+	e.list.set(key, value)
+	e.pending = append(e.pending, diskOp{Key: key, Value: value})
+}
+
+func (e *diskEngine) Delete(key string) bool {
+	e.pending = append(e.pending, diskOp{Key: key, Delete: true})
+	return e.list.delete(key)
+}
+
+func (e *diskEngine) Ascend(start string, fn func(key string, value []byte) bool) {
+	e.list.ascend(start, fn)
+}
+
+func writeRecord(w io.Writer, batch diskBatch) error {
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+	if _, err := w.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (e *diskEngine) Commit(appliedIndex int) error {
+	batch := diskBatch{AppliedIndex: appliedIndex, Ops: e.pending}
+	if err := writeRecord(e.file, batch); err != nil {
+		return err
+	}
+	if err := e.file.Sync(); err != nil {
+		return err
+	}
+	e.pending = nil
+	e.appliedIndex = appliedIndex
+	e.records++
+
+	if e.records >= diskRewriteThreshold {
+		return e.rewrite()
+	}
+	return nil
+}
+
+// rewrite replaces the data file with a single record holding the live keys,
+// the new file is synced and then renamed over the old one
+func (e *diskEngine) rewrite() error {
+	batch := diskBatch{AppliedIndex: e.appliedIndex, Ops: make([]diskOp, 0, e.list.length)}
+	e.list.ascend("", func(key string, value []byte) bool {
+		batch.Ops = append(batch.Ops, diskOp{Key: key, Value: value})
+		return true
+	})
+
+	tmpPath := e.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := writeRecord(tmp, batch); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmpPath, e.path); err != nil {
+		tmp.Close()
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(e.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	e.file.Close()
+	e.file = tmp
+	e.records = 1
+	return nil
+}
+
+func (e *diskEngine) AppliedIndex() int {
+	return e.appliedIndex
+}
+
+func (e *diskEngine) Close() error {
+	return e.file.Close()
+}
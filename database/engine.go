@@ -0,0 +1,56 @@
+package database
+
+// Engine is the ordered key value storage the state machine keeps its data in.
+// Writes are visible right away but only become durable on Commit, which
+// stores them atomically together with the index of the log entry they come
+// from, so after a restart only the entries after AppliedIndex are applied again
+type Engine interface {
+	Get(key string) ([]byte, bool)
+	Put(key string, value []byte)
+	Delete(key string) bool
+	// Ascend calls fn for every key >= start in order until fn returns false
+	Ascend(start string, fn func(key string, value []byte) bool)
+	Commit(appliedIndex int) error
+	AppliedIndex() int
+	Close() error
+}
+
+// memoryEngine keeps everything in a skip list, nothing survives a restart so
+// the whole committed log is applied again
+type memoryEngine struct {
+	list         *skipList
+	appliedIndex int
+}
+
+func NewMemoryEngine() Engine {
+	return &memoryEngine{list: newSkipList()}
+}
+
+func (m *memoryEngine) Get(key string) ([]byte, bool) {
+	return m.list.get(key)
+}
+
+func (m *memoryEngine) Put(key string, value []byte) {
+	m.list.set(key, value)
+}
+
+func (m *memoryEngine) Delete(key string) bool {
+	return m.list.delete(key)
+}
+
+func (m *memoryEngine) Ascend(start string, fn func(key string, value []byte) bool) {
+	m.list.ascend(start, fn)
+}
+
+func (m *memoryEngine) Commit(appliedIndex int) error {
+	m.appliedIndex = appliedIndex
+	return nil
+}
+
+func (m *memoryEngine) AppliedIndex() int {
+	return m.appliedIndex
+}
+
+func (m *memoryEngine) Close() error {
+	return nil
+}
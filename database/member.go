@@ -0,0 +1,86 @@
+package database
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/ssergomol/raft/persist"
+)
+
+// Membership changes are replicated as MEMBER name peer-addr client-addr so
+// that every node adds the member to the registry in its own data directory.
+// Applying the command again, when the log is replayed, is a no-op
+
+func validateMember(command string) error {
+	splits := strings.Split(command, " ")
+	if len(splits) != 4 {
+		return errors.New("need a name, a peer and a client address for MEMBER operation")
+	}
+	if splits[1] == "" || strings.ContainsAny(splits[1], "#,|") {
+		return errors.New("not a valid member name")
+	}
+	for _, addr := range splits[2:] {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return errors.New("not a valid address " + addr)
+		}
+	}
+	if splits[2] == splits[3] {
+		return errors.New("peer and client address of a member must be different")
+	}
+	return nil
+}
+
+// PerformMember adds a member to the registry unless its name or one of its
+// addresses is already taken by another member
+func (d *Database) PerformMember(command string) Result {
+	splits := strings.Split(command, " ")
+	name, peerAddr, clientAddr := splits[1], splits[2], splits[3]
+	peerAddrs, _ := persist.ListAllServers()
+	clientAddrs, _ := persist.ListClientAddrs()
+	if existing, ok := peerAddrs[name]; ok && (existing != peerAddr || clientAddrs[name] != clientAddr) {
+		return failedResult(StatusConditionFailed, "member "+name+" already exists")
+	}
+	for other := range peerAddrs {
+		if other == name {
+			continue
+		}
+		for _, addr := range []string{peerAddrs[other], clientAddrs[other]} {
+			if addr == peerAddr || addr == clientAddr {
+				return failedResult(StatusConditionFailed, "address "+addr+" is already used by member "+other)
+			}
+		}
+	}
+	if err := persist.AddServer(name, peerAddr, clientAddr); err != nil {
+		return failedResult(StatusError, err.Error())
+	}
+	return Result{Status: StatusOK, Message: "Added member " + name}
+}
+
+// The ID of a new cluster is generated by its first leader and replicated as
+// CLUSTER id, every member takes the ID of the first such entry committed.
+// Entries of other leaders that proposed an ID before learning it, and entries
+// applied again when the log is replayed, don't change it
+
+func validateCluster(command string) error {
+	splits := strings.Split(command, " ")
+	if len(splits) != 2 || splits[1] == "" || strings.ContainsAny(splits[1], "#,|") {
+		return errors.New("need a cluster id for CLUSTER operation")
+	}
+	return nil
+}
+
+// PerformCluster records the cluster ID unless the node already has one
+func (d *Database) PerformCluster(command string) Result {
+	id := strings.Split(command, " ")[1]
+	if current := persist.ClusterId(); current != "" {
+		if current != id {
+			return failedResult(StatusConditionFailed, "cluster ID is already "+current)
+		}
+		return Result{Status: StatusOK, Message: "Cluster ID is " + id}
+	}
+	if err := persist.SetClusterId(id); err != nil {
+		return failedResult(StatusError, err.Error())
+	}
+	return Result{Status: StatusOK, Message: "Cluster ID set to " + id}
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// Revision is a value a key had starting at the log index it was written at,
+// Deleted marks the index the key was removed at
+type Revision struct {
+	Index   int  `json:"index"`
+	Value   int  `json:"value,omitempty"`
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// ErrRevisionCompacted is returned for reads older than the compaction revision
+var ErrRevisionCompacted = errors.New("required revision has been compacted")
+
+// recordRevision keeps a new revision of key, it's called with mu held by every write
+func (d *Database) recordRevision(key string, rev Revision) {
+	d.db.putRevision(key, rev)
+}
+
+// getKeyAt returns the value key had once the log entry at index was applied
+func (d *Database) getKeyAt(key string, index int) (int, error) {
+	if index < d.compactRevision {
+		return -1, ErrRevisionCompacted
+	}
+	revs := d.db.revisions(key)
+	for i := len(revs) - 1; i >= 0; i-- {
+		if revs[i].Index <= index {
+			if revs[i].Deleted {
+				break
+			}
+			return revs[i].Value, nil
+		}
+	}
+	return -1, ErrKeyNotFound
+}
+
+// PerformGetAt returns the value of key as of the given log index
+func (d *Database) PerformGetAt(key string, index int) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	val, err := d.getKeyAt(key, index)
+	if err == ErrRevisionCompacted {
+		return "Revision " + strconv.Itoa(index) + " has been compacted, compaction revision is: " + strconv.Itoa(d.compactRevision)
+	}
+	if err != nil {
+		return "Key not found error"
+	}
+	return "Value for key (" + key + ") at revision " + strconv.Itoa(index) + " is: " + strconv.Itoa(val)
+}
+
+// History returns the revisions of key that weren't compacted yet, oldest first
+func (d *Database) History(key string) []Revision {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	revs := d.db.revisions(key)
+	if revs == nil {
+		revs = make([]Revision, 0)
+	}
+	return revs
+}
+
+// PerformHistory returns the revisions of key that weren't compacted yet as JSON, oldest first
+func (d *Database) PerformHistory(key string) string {
+	res, _ := json.Marshal(d.History(key))
+	return string(res)
+}
+
+// PerformCompact drops the revisions that are no longer needed to serve reads at
+// or after the given index. For every key the latest revision at or before the
+// index is kept unless it's a deletion
+func (d *Database) PerformCompact(index int) Result {
+	if index <= d.compactRevision {
+		return failedResult(StatusConditionFailed, "Compaction revision is already: "+strconv.Itoa(d.compactRevision))
+	}
+	for key, revs := range d.db.allRevisions() {
+		keep := 0
+		for keep < len(revs)-1 && revs[keep+1].Index <= index {
+			keep++
+		}
+		if revs[keep].Index <= index && revs[keep].Deleted {
+			keep++
+		}
+		for _, rev := range revs[:keep] {
+			d.db.deleteRevision(key, rev.Index)
+		}
+	}
+	d.compactRevision = index
+	d.db.setMeta("compact_revision", strconv.Itoa(index))
+	return Result{Status: StatusOK, Message: "Compacted revisions up to: " + strconv.Itoa(index), Version: index}
+}
+
+// CompactRevision returns the index reads older than are no longer served
+func (d *Database) CompactRevision() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.compactRevision
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func revisionEntries(d *Database) int {
+	count := 0
+	d.db.engine.Ascend(revisionPrefix, func(key string, value []byte) bool {
+		if !strings.HasPrefix(key, revisionPrefix) {
+			return false
+		}
+		count++
+		return true
+	})
+	return count
+}
+
+func TestRevisions(t *testing.T) {
+	d := newTestDatabase(t)
+	// a/00000000000000000001 looks like a revision of a, it must not be taken for one
+	apply(t, d, "SET a 1", "SET a/00000000000000000001 7", "SET a 2", "DELETE a", "SET a 3")
+	if n := revisionEntries(d); n != 5 {
+		t.Fatalf("%d revision entries, want one per write", n)
+	}
+	history := d.History("a")
+	if len(history) != 4 || history[0].Index != 1 || history[2].Index != 4 || !history[2].Deleted || history[3].Value != 3 {
+		t.Fatalf("History(a) = %+v", history)
+	}
+	if history := d.History("a/00000000000000000001"); len(history) != 1 || history[0].Value != 7 {
+		t.Fatalf("History(a/00000000000000000001) = %+v", history)
+	}
+	if value, err := d.getKeyAt("a", 3); err != nil || value != 2 {
+		t.Fatalf("a at 3 = %d, %v, want 2", value, err)
+	}
+	if _, err := d.getKeyAt("a", 4); err != ErrKeyNotFound {
+		t.Fatalf("a at 4 = %v, want ErrKeyNotFound", err)
+	}
+
+	apply(t, d, "COMPACT 4")
+	if history := d.History("a"); len(history) != 1 || history[0].Index != 5 {
+		t.Fatalf("History(a) after compaction = %+v, want the revision at 5 only", history)
+	}
+	if history := d.History("a/00000000000000000001"); len(history) != 1 {
+		t.Fatalf("History(a/00000000000000000001) after compaction = %+v, want its latest revision kept", history)
+	}
+}
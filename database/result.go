@@ -0,0 +1,40 @@
+package database
+
+// Status classifies the result of a command so the API can map it to a status code
+type Status int
+
+const (
+	StatusOK Status = iota
+	StatusNotFound
+	StatusConditionFailed
+	StatusInvalid
+	StatusError
+	// StatusUnavailable means the command may or may not have been applied, it's
+	// safe to retry within a client session
+	StatusUnavailable
+	// StatusStaleSequence means a session request is older than the last one the
+	// session applied, it was never applied and won't be
+	StatusStaleSequence
+)
+
+// Result is the outcome of applying a command. Message is the text answered by
+// the legacy API, Key, Value and Version describe the key once the command is applied
+type Result struct {
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	Key     string `json:"key,omitempty"`
+	Value   int    `json:"value"`
+	Version int    `json:"version,omitempty"`
+}
+
+func (r Result) String() string {
+	return r.Message
+}
+
+func okResult(message string, key string, value int, version int) Result {
+	return Result{Status: StatusOK, Message: message, Key: key, Value: value, Version: version}
+}
+
+func failedResult(status Status, message string) Result {
+	return Result{Status: status, Message: message}
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+const (
+	DefaultScanLimit = 100
+	MaxScanLimit     = 1000
+)
+
+// KeyValue is the state of a key returned by reads, TTL is the remaining
+// time-to-live in seconds of keys that expire
+type KeyValue struct {
+	Key     string `json:"key"`
+	Value   int    `json:"value"`
+	Version int    `json:"version,omitempty"`
+	TTL     int64  `json:"ttl,omitempty"`
+}
+
+func newKeyValue(key string, e entry, now time.Time) KeyValue {
+	kv := KeyValue{Key: key, Value: e.value, Version: e.version}
+	if e.expireAt != 0 {
+		kv.TTL = (e.expireAt - now.UnixMilli() + 999) / 1000
+	}
+	return kv
+}
+
+// ScanResponse is a page of a range scan, NextToken is empty on the last page
+type ScanResponse struct {
+	KeyValues []KeyValue `json:"kvs"`
+	NextToken string     `json:"next_token,omitempty"`
+}
+
+// EncodePageToken returns the opaque token a client passes to continue a scan at key
+func EncodePageToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// DecodePageToken returns the key a scan continues from
+func DecodePageToken(token string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", errors.New("invalid page token")
+	}
+	return string(key), nil
+}
+
+// PrefixEnd returns the smallest key greater than every key starting with
+// prefix, or "" (no upper bound) if there is none
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultScanLimit
+	}
+	if limit > MaxScanLimit {
+		return MaxScanLimit
+	}
+	return limit
+}
+
+// Scan returns up to limit keys in [start, end) in order, an empty end means
+// no upper bound. Expired keys are skipped
+func (d *Database) Scan(start string, end string, limit int) ScanResponse {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	now := time.Now()
+	limit = clampLimit(limit)
+	res := ScanResponse{KeyValues: make([]KeyValue, 0)}
+	d.db.ascend(start, func(key string, e entry) bool {
+		if end != "" && key >= end {
+			return false
+		}
+		if e.expired(now) {
+			return true
+		}
+		if len(res.KeyValues) == limit {
+			res.NextToken = EncodePageToken(key)
+			return false
+		}
+		res.KeyValues = append(res.KeyValues, newKeyValue(key, e, now))
+		return true
+	})
+	return res
+}
+
+// Count returns the number of keys starting with prefix
+func (d *Database) Count(prefix string) int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	now := time.Now()
+	end := PrefixEnd(prefix)
+	count := 0
+	d.db.ascend(prefix, func(key string, e entry) bool {
+		if end != "" && key >= end {
+			return false
+		}
+		if !e.expired(now) {
+			count++
+		}
+		return true
+	})
+	return count
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func ascendKeys(l *skipList, start string) []string {
+	var keys []string
+	l.ascend(start, func(key string, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func TestSkipListOrder(t *testing.T) {
+	l := newSkipList()
+	want := make(map[string]string)
+	rnd := rand.New(rand.NewSource(7))
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(rnd.Intn(500))
+		value := strconv.Itoa(i)
+		l.set(key, []byte(value))
+		want[key] = value
+		if rnd.Intn(4) == 0 {
+			deleted := strconv.Itoa(rnd.Intn(500))
+			if _, exists := want[deleted]; l.delete(deleted) != exists {
+				t.Fatalf("delete(%s) disagrees with the keys set", deleted)
+			}
+			delete(want, deleted)
+		}
+	}
+
+	keys := make([]string, 0, len(want))
+	for key := range want {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	got := ascendKeys(l, "")
+	if len(got) != len(keys) || l.length != len(keys) {
+		t.Fatalf("ascend gave %d keys, length %d, want %d", len(got), l.length, len(keys))
+	}
+	for i := range keys {
+		if got[i] != keys[i] {
+			t.Fatalf("key %d = %s, want %s", i, got[i], keys[i])
+		}
+		if value, ok := l.get(keys[i]); !ok || string(value) != want[keys[i]] {
+			t.Fatalf("get(%s) = %s, %v, want %s", keys[i], value, ok, want[keys[i]])
+		}
+	}
+
+	// ascend starts at the first key >= start, present or not
+	start := keys[len(keys)/2] + "0"
+	i := sort.SearchStrings(keys, start)
+	if got := ascendKeys(l, start); len(got) != len(keys)-i || (len(got) > 0 && got[0] != keys[i]) {
+		t.Fatalf("ascend from %s = %v, want %v", start, got, keys[i:])
+	}
+	if _, ok := l.get(start); ok {
+		t.Fatalf("get(%s) found a key never set", start)
+	}
+}
+
+func TestSkipListDeleteDuringAscend(t *testing.T) {
+	l := newSkipList()
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		l.set(key, []byte(key))
+	}
+	var seen []string
+	l.ascend("", func(key string, value []byte) bool {
+		seen = append(seen, key)
+		// the key being visited and the one after the next are deleted
+		l.delete(key)
+		if key == "b" {
+			l.delete("d")
+		}
+		return true
+	})
+	if got := joinKeys(seen); got != "a b c e f " {
+		t.Fatalf("visited %q, want every key but the deleted one", got)
+	}
+	if l.length != 0 || len(ascendKeys(l, "")) != 0 {
+		t.Fatalf("%d keys left after deleting every key", l.length)
+	}
+}
+
+func joinKeys(keys []string) string {
+	joined := ""
+	for _, key := range keys {
+		joined += key + " "
+	}
+	return joined
+}
+
+func scanKeys(response ScanResponse) []string {
+	keys := make([]string, 0, len(response.KeyValues))
+	for _, kv := range response.KeyValues {
+		keys = append(keys, kv.Key)
+	}
+	return keys
+}
+
+func TestScan(t *testing.T) {
+	d := newTestDatabase(t)
+	apply(t, d, "SET a 1", "SET b/1 2", "SET b/2 3", "SET b/3 4", "SET ba 5", "SET c 6")
+	apply(t, d, StampCommand("SET b/0 7 EX 1", time.Now().Add(-time.Hour)))
+
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		limit int
+		want  string
+		next  string
+	}{
+		{"every key", "", "", 0, "a b/1 b/2 b/3 ba c ", ""},
+		{"range", "b/2", "c", 0, "b/2 b/3 ba ", ""},
+		{"end is excluded", "a", "b/2", 0, "a b/1 ", ""},
+		{"prefix", "b/", PrefixEnd("b/"), 0, "b/1 b/2 b/3 ", ""},
+		{"empty range", "d", "", 0, "", ""},
+		{"limit", "", "", 2, "a b/1 ", "b/2"},
+		{"limit of a prefix", "b/", PrefixEnd("b/"), 3, "b/1 b/2 b/3 ", ""},
+	}
+	for _, tt := range tests {
+		response := d.Scan(tt.start, tt.end, tt.limit)
+		if got := joinKeys(scanKeys(response)); got != tt.want {
+			t.Errorf("%s: Scan(%q, %q, %d) = %q, want %q", tt.name, tt.start, tt.end, tt.limit, got, tt.want)
+		}
+		next := ""
+		if response.NextToken != "" {
+			next, _ = DecodePageToken(response.NextToken)
+		}
+		if next != tt.next {
+			t.Errorf("%s: next page starts at %q, want %q", tt.name, next, tt.next)
+		}
+	}
+
+	// paging through every key
+	var keys []string
+	start := ""
+	for {
+		response := d.Scan(start, "", 4)
+		keys = append(keys, scanKeys(response)...)
+		if response.NextToken == "" {
+			break
+		}
+		var err error
+		if start, err = DecodePageToken(response.NextToken); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := joinKeys(keys); got != "a b/1 b/2 b/3 ba c " {
+		t.Fatalf("pages = %q", got)
+	}
+
+	// a key deleted between two pages isn't returned
+	response := d.Scan("", "", 2)
+	apply(t, d, "DELETE b/2")
+	next, _ := DecodePageToken(response.NextToken)
+	if got := joinKeys(scanKeys(d.Scan(next, "", 0))); got != "b/3 ba c " {
+		t.Fatalf("page after deleting its first key = %q", got)
+	}
+	if d.Count("b/") != 2 || d.Count("") != 5 {
+		t.Fatalf("Count(b/) = %d, Count() = %d, want 2 and 5", d.Count("b/"), d.Count(""))
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, want := range map[string]string{"b/": "b0", "a": "b", "a\xff": "b", "\xff\xff": "", "": ""} {
+		if got := PrefixEnd(prefix); got != want {
+			t.Errorf("PrefixEnd(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clients that retry requests wrap their writes as "SESSION client-id seq command",
+// where seq increases with every new request of the client. The leader adds its
+// clock to the entry ("SESSION client-id seq unix-ms command") and the state
+// machine keeps, for every client, the last sequence number it applied together
+// with its result: a retried request that made it to the log twice is applied
+// only once and gets the cached result. Only the last result is kept, so a
+// client has at most one request in flight per session, a request older than
+// the last one applied is refused as a stale sequence without being applied.
+// Sessions that saw no request for
+// SessionTimeout, measured with the leader timestamps in the log, are dropped
+// when the next session entry is applied, so every replica expires them at the
+// same log position
+const SessionTimeout = 10 * time.Minute
+
+const sessionPrefix = "s/"
+
+// session is the last request applied for a client
+type session struct {
+	Seq      int    `json:"seq"`
+	LastSeen int64  `json:"last_seen"`
+	Result   Result `json:"result"`
+}
+
+// WithSession wraps command in a session so it's applied at most once
+func WithSession(clientId string, seq int, command string) string {
+	return "SESSION " + clientId + " " + strconv.Itoa(seq) + " " + command
+}
+
+func (s *store) session(clientId string) (session, bool) {
+	data, exists := s.engine.Get(sessionPrefix + clientId)
+	if !exists {
+		return session{}, false
+	}
+	var sess session
+	json.Unmarshal(data, &sess)
+	return sess, true
+}
+
+func (s *store) setSession(clientId string, sess session) {
+	data, _ := json.Marshal(sess)
+	s.engine.Put(sessionPrefix+clientId, data)
+}
+
+func (s *store) ascendSessions(fn func(clientId string, sess session) bool) {
+	s.engine.Ascend(sessionPrefix, func(key string, value []byte) bool {
+		if !strings.HasPrefix(key, sessionPrefix) {
+			return false
+		}
+		var sess session
+		json.Unmarshal(value, &sess)
+		return fn(strings.TrimPrefix(key, sessionPrefix), sess)
+	})
+}
+
+func validateSession(command string) error {
+	splits := strings.SplitN(command, " ", 4)
+	if len(splits) != 4 {
+		return errors.New("need a client id, a sequence number and a command for SESSION operation")
+	}
+	if strings.ContainsAny(splits[1], "#,|") {
+		return errors.New("not a valid client id")
+	}
+	if seq, err := strconv.Atoi(splits[2]); err != nil || seq <= 0 {
+		return errors.New("not a valid sequence number")
+	}
+	if strings.HasPrefix(splits[3], "SESSION ") {
+		return errors.New("nested SESSION operation")
+	}
+	return nil
+}
+
+// stampSession adds the leader's clock to a session command
+func stampSession(command string, now time.Time) string {
+	splits := strings.SplitN(command, " ", 4)
+	if len(splits) != 4 {
+		return command
+	}
+	return strings.Join([]string{splits[0], splits[1], splits[2], strconv.FormatInt(now.UnixMilli(), 10), StampCommand(splits[3], now)}, " ")
+}
+
+func (d *Database) expireSessions(now int64) {
+	expired := make([]string, 0)
+	d.db.ascendSessions(func(clientId string, sess session) bool {
+		if sess.LastSeen+SessionTimeout.Milliseconds() < now {
+			expired = append(expired, clientId)
+		}
+		return true
+	})
+	for _, clientId := range expired {
+		d.db.engine.Delete(sessionPrefix + clientId)
+	}
+}
+
+// applySession applies the command of a stamped session entry unless the client's
+// session already has it, in which case the cached result is returned
+func (d *Database) applySession(index int, command string) Result {
+	splits := strings.SplitN(command, " ", 5)
+	if len(splits) != 5 {
+		return failedResult(StatusInvalid, "invalid SESSION command")
+	}
+	clientId := splits[1]
+	seq, _ := strconv.Atoi(splits[2])
+	now, _ := strconv.ParseInt(splits[3], 10, 64)
+
+	d.expireSessions(now)
+	sess, exists := d.db.session(clientId)
+	if exists && seq == sess.Seq {
+		return sess.Result
+	}
+	if exists && seq < sess.Seq {
+		return failedResult(StatusStaleSequence, "Stale sequence "+strconv.Itoa(seq)+" of session "+clientId+", request "+strconv.Itoa(sess.Seq)+" was applied after it")
+	}
+
+	result := d.apply(index, splits[4])
+	d.db.setSession(clientId, session{Seq: seq, LastSeen: now, Result: result})
+	return result
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// applyInSession applies command as request seq of the session of clientId,
+// stamped by the leader at now
+func applyInSession(t *testing.T, d *Database, clientId string, seq int, command string, now time.Time) Result {
+	t.Helper()
+	command = WithSession(clientId, seq, command)
+	if err := d.ValidateCommand(command); err != nil {
+		t.Fatalf("ValidateCommand(%q): %v", command, err)
+	}
+	return d.PerformDbOperations(d.AppliedIndex()+1, StampCommand(command, now))
+}
+
+func TestSessionReplayAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.db")
+	engine, err := OpenDiskEngine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDatabase(engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	applyInSession(t, d, "c1", 1, "INCR k", now)
+	first := applyInSession(t, d, "c1", 2, "INCR k 10", now)
+	if first.Status != StatusOK || first.Value != 11 {
+		t.Fatalf("request 2 = %+v, want k incremented to 11", first)
+	}
+	engine.Close()
+
+	engine, err = OpenDiskEngine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	d, err = NewDatabase(engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a retry of the last request that reached the log again after the restart
+	replayed := applyInSession(t, d, "c1", 2, "INCR k 10", now)
+	if replayed != first {
+		t.Fatalf("replayed request 2 = %+v, want the cached %+v", replayed, first)
+	}
+	if value, _ := d.getKey("k"); value != 11 {
+		t.Fatalf("k = %d after the replay, want 11", value)
+	}
+
+	stale := applyInSession(t, d, "c1", 1, "INCR k", now)
+	if stale.Status != StatusStaleSequence {
+		t.Fatalf("request 1 after request 2 = %+v, want a stale sequence", stale)
+	}
+	if value, _ := d.getKey("k"); value != 11 {
+		t.Fatalf("k = %d after the stale request, want 11", value)
+	}
+
+	if next := applyInSession(t, d, "c1", 3, "INCR k", now); next.Status != StatusOK || next.Value != 12 {
+		t.Fatalf("request 3 = %+v, want k incremented to 12", next)
+	}
+	if other := applyInSession(t, d, "c2", 1, "INCR k", now); other.Status != StatusOK || other.Value != 13 {
+		t.Fatalf("request 1 of another session = %+v, want k incremented to 13", other)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	d := newTestDatabase(t)
+	now := time.Now()
+	applyInSession(t, d, "c1", 5, "INCR k", now)
+
+	// once the session expired its requests are applied as new ones
+	later := now.Add(SessionTimeout + time.Second)
+	if result := applyInSession(t, d, "c2", 1, "INCR other", later); result.Status != StatusOK {
+		t.Fatalf("request of another session = %+v", result)
+	}
+	if result := applyInSession(t, d, "c1", 5, "INCR k", later); result.Status != StatusOK || result.Value != 2 {
+		t.Fatalf("request 5 after the session expired = %+v, want k incremented to 2", result)
+	}
+}
@@ -0,0 +1,109 @@
+package database
+
+import "math/rand"
+
+const (
+	skipListMaxLevel = 16
+	skipListP        = 0.25
+)
+
+type skipListNode struct {
+	key   string
+	value []byte
+	next  []*skipListNode
+}
+
+// skipList keeps the values ordered by key so ranges and prefixes can be scanned
+type skipList struct {
+	head   *skipListNode
+	level  int
+	length int
+	rnd    *rand.Rand
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &skipListNode{next: make([]*skipListNode, skipListMaxLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewSource(1)),
+	}
+}
+
+func (l *skipList) randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && l.rnd.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// findGreaterOrEqual returns the first node with a key >= key and fills prev with
+// the last node before it on every level
+func (l *skipList) findGreaterOrEqual(key string, prev []*skipListNode) *skipListNode {
+	node := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+		if prev != nil {
+			prev[i] = node
+		}
+	}
+	return node.next[0]
+}
+
+func (l *skipList) get(key string) ([]byte, bool) {
+	node := l.findGreaterOrEqual(key, nil)
+	if node == nil || node.key != key {
+		return nil, false
+	}
+	return node.value, true
+}
+
+func (l *skipList) set(key string, value []byte) {
+	prev := make([]*skipListNode, skipListMaxLevel)
+	node := l.findGreaterOrEqual(key, prev)
+	if node != nil && node.key == key {
+		node.value = value
+		return
+	}
+
+	level := l.randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			prev[i] = l.head
+		}
+		l.level = level
+	}
+	node = &skipListNode{key: key, value: value, next: make([]*skipListNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = prev[i].next[i]
+		prev[i].next[i] = node
+	}
+	l.length++
+}
+
+func (l *skipList) delete(key string) bool {
+	prev := make([]*skipListNode, skipListMaxLevel)
+	node := l.findGreaterOrEqual(key, prev)
+	if node == nil || node.key != key {
+		return false
+	}
+	for i := 0; i < len(node.next); i++ {
+		prev[i].next[i] = node.next[i]
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+	l.length--
+	return true
+}
+
+// ascend calls fn for every value with a key >= start in order until fn returns false
+func (l *skipList) ascend(start string, fn func(key string, value []byte) bool) {
+	for node := l.findGreaterOrEqual(start, nil); node != nil; node = node.next[0] {
+		if !fn(node.key, node.value) {
+			return
+		}
+	}
+}
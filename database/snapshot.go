@@ -0,0 +1,26 @@
+package database
+
+// Snapshot is a copy of everything the state machine holds, the key space
+// prefixes of the entries are those of store
+type Snapshot struct {
+	AppliedIndex int             `json:"applied_index"`
+	Entries      []SnapshotEntry `json:"entries"`
+}
+
+type SnapshotEntry struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Snapshot returns a consistent copy of the state machine
+func (d *Database) Snapshot() Snapshot {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	snapshot := Snapshot{AppliedIndex: d.db.engine.AppliedIndex(), Entries: make([]SnapshotEntry, 0)}
+	d.db.engine.Ascend("", func(key string, value []byte) bool {
+		snapshot.Entries = append(snapshot.Entries, SnapshotEntry{Key: key, Value: append([]byte(nil), value...)})
+		return true
+	})
+	return snapshot
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The engine holds several key spaces: the current entries of the keys, their
+// revisions and metadata of the state machine such as the compaction revision.
+// Every revision is an entry of its own, r/<key>/<index> with the index padded
+// to revisionIndexWidth digits so the revisions of a key are in order, and a
+// write only adds one entry
+const (
+	entryPrefix    = "k/"
+	revisionPrefix = "r/"
+	metaPrefix     = "m/"
+
+	revisionIndexWidth = 20
+)
+
+// store gives typed access to the key spaces of an Engine
+type store struct {
+	engine Engine
+}
+
+func encodeEntry(e entry) []byte {
+	return []byte(strconv.Itoa(e.value) + "," + strconv.Itoa(e.version) + "," + strconv.FormatInt(e.expireAt, 10))
+}
+
+func decodeEntry(data []byte) entry {
+	splits := strings.Split(string(data), ",")
+	value, _ := strconv.Atoi(splits[0])
+	version, _ := strconv.Atoi(splits[1])
+	expireAt, _ := strconv.ParseInt(splits[2], 10, 64)
+	return entry{value: value, version: version, expireAt: expireAt}
+}
+
+func (s *store) get(key string) (entry, bool) {
+	data, exists := s.engine.Get(entryPrefix + key)
+	if !exists {
+		return entry{}, false
+	}
+	return decodeEntry(data), true
+}
+
+func (s *store) set(key string, e entry) {
+	s.engine.Put(entryPrefix+key, encodeEntry(e))
+}
+
+func (s *store) delete(key string) bool {
+	return s.engine.Delete(entryPrefix + key)
+}
+
+// ascend calls fn for every entry with a key >= start in order until fn returns false
+func (s *store) ascend(start string, fn func(key string, e entry) bool) {
+	s.engine.Ascend(entryPrefix+start, func(key string, value []byte) bool {
+		if !strings.HasPrefix(key, entryPrefix) {
+			return false
+		}
+		return fn(strings.TrimPrefix(key, entryPrefix), decodeEntry(value))
+	})
+}
+
+func revisionKey(key string, index int) string {
+	return revisionPrefix + key + "/" + fmt.Sprintf("%0*d", revisionIndexWidth, index)
+}
+
+// parseRevisionKey returns the key and the index of a revision entry, ok is
+// false if engineKey isn't one
+func parseRevisionKey(engineKey string) (key string, index int, ok bool) {
+	if !strings.HasPrefix(engineKey, revisionPrefix) {
+		return "", 0, false
+	}
+	rest := strings.TrimPrefix(engineKey, revisionPrefix)
+	i := strings.LastIndex(rest, "/")
+	if i < 0 || len(rest)-i-1 != revisionIndexWidth {
+		return "", 0, false
+	}
+	index, err := strconv.Atoi(rest[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return rest[:i], index, true
+}
+
+// revisions returns the revisions of key, oldest first
+func (s *store) revisions(key string) []Revision {
+	var revs []Revision
+	prefix := revisionPrefix + key + "/"
+	s.engine.Ascend(prefix, func(engineKey string, value []byte) bool {
+		if !strings.HasPrefix(engineKey, prefix) {
+			return false
+		}
+		// the revisions of the keys under key/ share the prefix
+		if k, _, ok := parseRevisionKey(engineKey); !ok || k != key {
+			return true
+		}
+		var rev Revision
+		json.Unmarshal(value, &rev)
+		revs = append(revs, rev)
+		return true
+	})
+	return revs
+}
+
+func (s *store) putRevision(key string, rev Revision) {
+	data, _ := json.Marshal(rev)
+	s.engine.Put(revisionKey(key, rev.Index), data)
+}
+
+func (s *store) deleteRevision(key string, index int) {
+	s.engine.Delete(revisionKey(key, index))
+}
+
+// allRevisions returns the revisions of every key, oldest first
+func (s *store) allRevisions() map[string][]Revision {
+	all := make(map[string][]Revision)
+	s.engine.Ascend(revisionPrefix, func(engineKey string, value []byte) bool {
+		if !strings.HasPrefix(engineKey, revisionPrefix) {
+			return false
+		}
+		if key, _, ok := parseRevisionKey(engineKey); ok {
+			var rev Revision
+			json.Unmarshal(value, &rev)
+			all[key] = append(all[key], rev)
+		}
+		return true
+	})
+	return all
+}
+
+func (s *store) meta(name string) (string, bool) {
+	data, exists := s.engine.Get(metaPrefix + name)
+	return string(data), exists
+}
+
+func (s *store) setMeta(name string, value string) {
+	s.engine.Put(metaPrefix+name, []byte(value))
+}
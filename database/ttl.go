@@ -0,0 +1,122 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys with a time-to-live are written as "SET key value EX seconds" by clients.
+// Followers apply entries at different wall clock times, so the leader turns the
+// relative TTL into an absolute deadline ("SET key value PXAT unix-ms") before
+// the command enters the log, and later replicates an "EXPIRE key unix-ms" entry
+// once the deadline has passed. Every replica therefore removes the key at the
+// same log position, reads only hide keys whose deadline is already over.
+//
+// Until the EXPIRE entry is applied, commands that go through the log (SETNX,
+// CAS, DELIFEQ, INCR/DECR, GETSET and the compares of a TXN) still see a key
+// whose deadline is over while reads hide it. Entries carry no clock to check
+// the deadline against, and every replica has to apply them alike, so the
+// window lasts until the leader's next expiry check replicates the EXPIRE.
+
+// maxExpirySeconds is the longest TTL whose deadline doesn't overflow a Duration
+const maxExpirySeconds = math.MaxInt64 / int64(time.Second)
+
+func validateExpiry(option string, value string) error {
+	if option != "EX" && option != "PXAT" {
+		return errors.New("invalid SET option " + option + ", expected EX")
+	}
+	amount, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || amount <= 0 {
+		return errors.New("not a valid expiration time")
+	}
+	if option == "EX" && amount > maxExpirySeconds {
+		return errors.New("expiration time can be at most " + strconv.FormatInt(maxExpirySeconds, 10) + " seconds")
+	}
+	return nil
+}
+
+// StampCommand fills in the values of a command that come from the leader's clock
+// before it enters the log: the absolute expiration of a SET with a TTL and the
+// timestamp of a session. AUTH commands get their password hashes and token
+// secret here as well. Other commands are returned as is
+func StampCommand(command string, now time.Time) string {
+	splits := strings.Split(command, " ")
+	if splits[0] == "SESSION" {
+		return stampSession(command, now)
+	}
+	if splits[0] == "AUTH" {
+		return stampAuth(command)
+	}
+	if splits[0] != "SET" || len(splits) != 5 || splits[3] != "EX" {
+		return command
+	}
+	seconds, _ := strconv.ParseInt(splits[4], 10, 64)
+	expireAt := now.Add(time.Duration(seconds) * time.Second).UnixMilli()
+	return strings.Join([]string{splits[0], splits[1], splits[2], "PXAT", strconv.FormatInt(expireAt, 10)}, " ")
+}
+
+func (d *Database) setExpiry(key string, expireAt int64) {
+	e, exists := d.db.get(key)
+	if !exists {
+		return
+	}
+	e.expireAt = expireAt
+	d.db.set(key, e)
+}
+
+func (e entry) expired(now time.Time) bool {
+	return e.expireAt != 0 && e.expireAt <= now.UnixMilli()
+}
+
+func (d *Database) isExpired(key string, now time.Time) bool {
+	e, exists := d.db.get(key)
+	return exists && e.expired(now)
+}
+
+// ExpiredKeys returns the EXPIRE commands the leader has to replicate for keys
+// whose deadline is over
+func (d *Database) ExpiredKeys(now time.Time) []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	commands := make([]string, 0)
+	d.db.ascend("", func(key string, e entry) bool {
+		if e.expired(now) {
+			commands = append(commands, "EXPIRE "+key+" "+strconv.FormatInt(e.expireAt, 10))
+		}
+		return true
+	})
+	return commands
+}
+
+// PerformExpire removes key if it still carries the given deadline, a key that
+// was written again after the EXPIRE command was proposed is kept
+func (d *Database) PerformExpire(index int, key string, expireAt int64) Result {
+	e, exists := d.db.get(key)
+	if !exists || e.expireAt != expireAt {
+		return failedResult(StatusConditionFailed, "Key not expired")
+	}
+	d.deleteKey(key, index)
+	return okResult("Key expired successfully", key, 0, index)
+}
+
+// PerformTTL returns the remaining time-to-live of key in seconds, -1 if the key
+// doesn't expire and -2 if it doesn't exist
+func (d *Database) PerformTTL(key string) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	now := time.Now()
+	e, exists := d.db.get(key)
+	ttl := int64(-2)
+	if exists && !e.expired(now) {
+		ttl = -1
+		if e.expireAt != 0 {
+			ttl = (e.expireAt - now.UnixMilli() + 999) / 1000
+		}
+	}
+	return "TTL for key (" + key + ") is: " + strconv.FormatInt(ttl, 10)
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Compare is a guard of a transaction, it compares the value, the version or the
+// existence (1 if the key exists, 0 otherwise) of a key with the given value
+type Compare struct {
+	Key    string `json:"key"`
+	Target string `json:"target"`
+	Op     string `json:"op"`
+	Value  int    `json:"value"`
+}
+
+// TxnOp is a write performed by a transaction, either SET or DELETE
+type TxnOp struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value int    `json:"value,omitempty"`
+}
+
+// Txn is applied atomically as a single log entry: if all the guards in Compare
+// hold the Success operations are performed, otherwise the Failure ones are
+type Txn struct {
+	Compare []Compare `json:"compare"`
+	Success []TxnOp   `json:"success"`
+	Failure []TxnOp   `json:"failure"`
+}
+
+// TxnResponse reports which branch of a transaction was applied and the log index it was applied at
+type TxnResponse struct {
+	Succeeded bool `json:"succeeded"`
+	Revision  int  `json:"revision"`
+}
+
+// ParseTxn decodes and validates a transaction received as JSON
+func ParseTxn(data []byte) (*Txn, error) {
+	var txn Txn
+	if err := json.Unmarshal(data, &txn); err != nil {
+		return nil, errors.New("invalid transaction, bad request")
+	}
+	if err := txn.Validate(); err != nil {
+		return nil, err
+	}
+	return &txn, nil
+}
+
+// Validate checks every guard and operation of the transaction
+func (t *Txn) Validate() error {
+	for _, cmp := range t.Compare {
+		if !ValidKey(cmp.Key) {
+			return errors.New("need a valid key for transaction compare")
+		}
+		if cmp.Target != "value" && cmp.Target != "version" && cmp.Target != "exists" {
+			return errors.New("invalid compare target " + cmp.Target)
+		}
+		if cmp.Op != "=" && cmp.Op != "!=" && cmp.Op != "<" && cmp.Op != ">" {
+			return errors.New("invalid compare operator " + cmp.Op)
+		}
+	}
+	for _, op := range append(append([]TxnOp{}, t.Success...), t.Failure...) {
+		if !ValidKey(op.Key) {
+			return errors.New("need a valid key for transaction operation")
+		}
+		if op.Op != "SET" && op.Op != "DELETE" {
+			return errors.New("invalid transaction operation " + op.Op)
+		}
+	}
+	return nil
+}
+
+// Command encodes the transaction as a single TXN command that can be stored in the log
+func (t *Txn) Command() (string, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return "TXN " + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func decodeTxnCommand(command string) (*Txn, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(command, "TXN "))
+	if err != nil {
+		return nil, errors.New("invalid transaction encoding")
+	}
+	return ParseTxn(data)
+}
+
+func (d *Database) compare(cmp Compare) bool {
+	var current int
+	switch cmp.Target {
+	case "value":
+		val, err := d.getKey(cmp.Key)
+		if err != nil {
+			return false
+		}
+		current = val
+	case "version":
+		current = d.getVersion(cmp.Key)
+	case "exists":
+		if _, err := d.getKey(cmp.Key); err == nil {
+			current = 1
+		}
+	}
+
+	switch cmp.Op {
+	case "=":
+		return current == cmp.Value
+	case "!=":
+		return current != cmp.Value
+	case "<":
+		return current < cmp.Value
+	case ">":
+		return current > cmp.Value
+	}
+	return false
+}
+
+// PerformTxn evaluates the guards of a transaction and applies one of its branches.
+// Operations are validated before the log entry is created and neither SET nor
+// DELETE of a missing key can fail, so a branch is always applied as a whole
+func (d *Database) PerformTxn(index int, txn *Txn) Result {
+	succeeded := true
+	for _, cmp := range txn.Compare {
+		if !d.compare(cmp) {
+			succeeded = false
+			break
+		}
+	}
+
+	ops := txn.Success
+	if !succeeded {
+		ops = txn.Failure
+	}
+	for _, op := range ops {
+		if op.Op == "SET" {
+			d.setKey(op.Key, op.Value, index)
+		} else {
+			d.deleteKey(op.Key, index)
+		}
+	}
+
+	res, _ := json.Marshal(TxnResponse{Succeeded: succeeded, Revision: index})
+	return Result{Status: StatusOK, Message: string(res), Version: index}
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// WatchHistorySize is the number of past events kept to resume watches
+	WatchHistorySize = 1000
+	watchBufferSize  = 256
+)
+
+// ErrCompacted is returned when a watch resumes from an index whose events are
+// no longer kept in the history
+var ErrCompacted = errors.New("requested index is older than the watch history")
+
+// Event is a change of a key applied by the state machine, tagged with the
+// index of the log entry that caused it
+type Event struct {
+	Type  string `json:"type"`
+	Key   string `json:"key"`
+	Value int    `json:"value,omitempty"`
+	Index int    `json:"index"`
+}
+
+// Watcher receives the events of a key, or of every key under a prefix.
+// Events is closed when the watcher is cancelled or falls too far behind,
+// in which case the client can resume from the index of the last event it got
+type Watcher struct {
+	key    string
+	prefix bool
+	events chan Event
+}
+
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+func (w *Watcher) matches(key string) bool {
+	if w.prefix {
+		return strings.HasPrefix(key, w.key)
+	}
+	return key == w.key
+}
+
+// Watch registers a watcher for key (or every key starting with it if prefix is
+// set). If fromIndex is positive the events with an index >= fromIndex that are
+// still in the history are delivered first
+func (d *Database) Watch(key string, prefix bool, fromIndex int) (*Watcher, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if fromIndex > 0 && fromIndex <= d.compactedIndex {
+		return nil, ErrCompacted
+	}
+
+	w := &Watcher{key: key, prefix: prefix, events: make(chan Event, watchBufferSize+WatchHistorySize)}
+	if fromIndex > 0 {
+		for _, event := range d.history {
+			if event.Index >= fromIndex && w.matches(event.Key) {
+				w.events <- event
+			}
+		}
+	}
+	d.watchers[w] = struct{}{}
+	return w, nil
+}
+
+// Unwatch cancels a watcher and closes its channel
+func (d *Database) Unwatch(w *Watcher) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.watchers[w]; ok {
+		delete(d.watchers, w)
+		close(w.events)
+	}
+}
+
+// notify records an event in the history and hands it to the matching watchers,
+// it's called with mu held by the write that caused the event
+func (d *Database) notify(event Event) {
+	d.history = append(d.history, event)
+	if len(d.history) > WatchHistorySize {
+		d.compactedIndex = d.history[0].Index
+		d.history = d.history[1:]
+	}
+
+	for w := range d.watchers {
+		if !w.matches(event.Key) {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			// the watcher isn't keeping up, drop it rather than block the state machine
+			delete(d.watchers, w)
+			close(w.events)
+		}
+	}
+}
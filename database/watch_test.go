@@ -0,0 +1,43 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchFromIndexBeforeRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.db")
+	engine, err := OpenDiskEngine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDatabase(engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply(t, d, "SET a 1", "SET b 2")
+	engine.Close()
+
+	// entries the engine holds aren't applied again after a restart, so their
+	// events can't be delivered
+	engine, err = OpenDiskEngine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	d, err = NewDatabase(engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Watch("b", false, 1); err != ErrCompacted {
+		t.Fatalf("Watch from an index before the restart = %v, want ErrCompacted", err)
+	}
+	w, err := d.Watch("b", false, 3)
+	if err != nil {
+		t.Fatalf("Watch from the next index: %v", err)
+	}
+	apply(t, d, "SET b 3")
+	if event := <-w.Events(); event.Index != 3 || event.Value != 3 {
+		t.Fatalf("event = %+v, want b set to 3 at index 3", event)
+	}
+}
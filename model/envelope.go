@@ -0,0 +1,43 @@
+package model
+
+import (
+	"errors"
+	"strings"
+)
+
+// Envelope addresses a raft message to one node of one cluster, so that nodes
+// of another cluster, or a stale process on a reused port, can't have their
+// messages accepted. The W3C traceparent of the span that sent the message, if
+// any, lets the recipient join its trace. It's sent as a header line followed
+// by the message
+type Envelope struct {
+	ClusterId   string
+	SenderId    string
+	RecipientId string
+	Traceparent string
+	Message     string
+}
+
+func (e *Envelope) String() string {
+	return "Envelope" + "|" + e.ClusterId + "|" + e.SenderId + "|" + e.RecipientId + "|" + e.Traceparent + "\n" + e.Message
+}
+
+func ParseEnvelope(data string) (*Envelope, error) {
+	lines := strings.SplitN(data, "\n", 2)
+	splits := strings.Split(lines[0], "|")
+	if len(lines) != 2 || len(splits) != 5 || splits[0] != "Envelope" {
+		return nil, errors.New("not a raft message envelope")
+	}
+	envelope := NewEnvelope(splits[1], splits[2], splits[3], strings.TrimSpace(lines[1]))
+	envelope.Traceparent = splits[4]
+	return envelope, nil
+}
+
+func NewEnvelope(clusterId string, senderId string, recipientId string, message string) *Envelope {
+	return &Envelope{
+		ClusterId:   clusterId,
+		SenderId:    senderId,
+		RecipientId: recipientId,
+		Message:     message,
+	}
+}
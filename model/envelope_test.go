@@ -0,0 +1,29 @@
+package model
+
+import "testing"
+
+func TestEnvelopeTraceparent(t *testing.T) {
+	traceparent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	envelope := NewEnvelope("cluster", "n1", "n2", "LogRequest|n1|1|0|0|0|SET k 1#1")
+	envelope.Traceparent = traceparent
+
+	parsed, err := ParseEnvelope(envelope.String())
+	if err != nil {
+		t.Fatalf("ParseEnvelope: %v", err)
+	}
+	if *parsed != *envelope {
+		t.Fatalf("ParseEnvelope(String()) = %+v, want %+v", parsed, envelope)
+	}
+
+	// envelopes of nodes that don't trace have an empty traceparent field
+	parsed, err = ParseEnvelope("Envelope|cluster|n1|n2|\nLogRequest|n1|1|0|0|0|")
+	if err != nil {
+		t.Fatalf("ParseEnvelope without traceparent: %v", err)
+	}
+	if parsed.Traceparent != "" || parsed.Message != "LogRequest|n1|1|0|0|0|" {
+		t.Fatalf("ParseEnvelope without traceparent = %+v", parsed)
+	}
+	if _, err := ParseEnvelope("Envelope|cluster|n1|n2\nLogRequest|n1|1|0|0|0|"); err == nil {
+		t.Fatal("ParseEnvelope accepted a header without the traceparent field")
+	}
+}
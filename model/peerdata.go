@@ -1,17 +1,124 @@
 package model
 
+import "sync"
+
+// PeerData is what a node knows about its peers. The replication progress and
+// the suspected nodes are written by the goroutines handling peer responses
+// and read by the status and metrics handlers, so they're only reached through
+// the methods below
 type PeerData struct {
-	VotesReceived  map[string]bool
+	VotesReceived map[string]bool
+
+	mu             sync.Mutex
+	ackedLength    map[string]int
+	sentLength     map[string]int
+	suspectedNodes map[string]bool
+}
+
+// PeerProgress is a copy of the replication progress of the peers and of the
+// addresses of the suspected nodes
+type PeerProgress struct {
 	AckedLength    map[string]int
 	SentLength     map[string]int
-	SuspectedNodes map[int]bool
+	SuspectedNodes map[string]bool
 }
 
 func NewPeerData() *PeerData {
 	return &PeerData{
 		VotesReceived:  make(map[string]bool),
-		AckedLength:    make(map[string]int),
-		SentLength:     make(map[string]int),
-		SuspectedNodes: make(map[int]bool),
+		ackedLength:    make(map[string]int),
+		sentLength:     make(map[string]int),
+		suspectedNodes: make(map[string]bool),
+	}
+}
+
+// AckedLength returns the number of log entries node is known to have
+func (p *PeerData) AckedLength(node string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ackedLength[node]
+}
+
+// SetAckedLength records that node has the first length log entries
+func (p *PeerData) SetAckedLength(node string, length int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ackedLength[node] = length
+}
+
+// SentLength returns the number of log entries sent to node
+func (p *PeerData) SentLength(node string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sentLength[node]
+}
+
+// Ack records that node has the first length log entries, all of them sent to
+// it. An ack older than the one known is ignored and false is returned
+func (p *PeerData) Ack(node string, length int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if length < p.ackedLength[node] {
+		return false
+	}
+	p.sentLength[node] = length
+	p.ackedLength[node] = length
+	return true
+}
+
+// DecrementSentLength steps back the entries sent to node by one, after it
+// refused entries that don't follow its log
+func (p *PeerData) DecrementSentLength(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sentLength[node]--
+}
+
+// Suspect marks the node at addr as unreachable and tells if it was reachable
+// before
+func (p *PeerData) Suspect(addr string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	suspected := p.suspectedNodes[addr]
+	p.suspectedNodes[addr] = true
+	return !suspected
+}
+
+// Recover marks the node at addr as reachable and tells if it was suspected
+// before
+func (p *PeerData) Recover(addr string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, suspected := p.suspectedNodes[addr]
+	delete(p.suspectedNodes, addr)
+	return suspected
+}
+
+// SuspectedCount returns the number of nodes marked as unreachable
+func (p *PeerData) SuspectedCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.suspectedNodes)
+}
+
+// Progress returns a copy of the replication progress and the suspected nodes
+// taken at once
+func (p *PeerData) Progress() PeerProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	progress := PeerProgress{
+		AckedLength:    make(map[string]int, len(p.ackedLength)),
+		SentLength:     make(map[string]int, len(p.sentLength)),
+		SuspectedNodes: make(map[string]bool, len(p.suspectedNodes)),
+	}
+	for node, length := range p.ackedLength {
+		progress.AckedLength[node] = length
+	}
+	for node, length := range p.sentLength {
+		progress.SentLength[node] = length
+	}
+	for addr, suspected := range p.suspectedNodes {
+		progress.SuspectedNodes[addr] = suspected
 	}
+	return progress
 }
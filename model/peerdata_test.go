@@ -0,0 +1,43 @@
+package model
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestPeerDataProgressWhileReplicating(t *testing.T) {
+	p := NewPeerData()
+	var wg sync.WaitGroup
+	for _, node := range []string{"n2", "n3"} {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			for length := 1; length <= 1000; length++ {
+				p.Ack(node, length)
+				if length%10 == 0 {
+					p.Suspect(node)
+					p.Recover(node)
+				}
+			}
+		}(node)
+	}
+	for i := 0; i < 100; i++ {
+		progress := p.Progress()
+		for node, acked := range progress.AckedLength {
+			if sent := progress.SentLength[node]; sent != acked {
+				t.Fatalf("progress of %s: sent %d, acked %d, want a snapshot taken at once", node, sent, acked)
+			}
+		}
+	}
+	wg.Wait()
+
+	if p.Ack("n2", 999) {
+		t.Fatal("an ack older than the known one was recorded")
+	}
+	if acked := p.AckedLength("n2"); acked != 1000 {
+		t.Fatalf("acked length of n2 = %d, want 1000", acked)
+	}
+	if !p.Suspect("n2") || p.Suspect("n2") || !p.Recover("n2") || p.Recover("n2") {
+		t.Fatal("Suspect and Recover must only report a change of reachability")
+	}
+}
@@ -1,13 +1,14 @@
 package model
 
 import (
-	"fmt"
 	"strconv"
 	"strings"
 
-	"github.com/ssergomol/raft/logger"
+	"github.com/ssergomol/raft/persist"
 )
 
+// ServerState is the hard state of a node, it's persisted before the node acts
+// on a new term, vote or commit length so that a restart can't undo them
 type ServerState struct {
 	Name         string
 	CurrentTerm  int
@@ -15,16 +16,15 @@ type ServerState struct {
 	CommitLength int
 }
 
-func (serverState *ServerState) LogServerPersistedState() {
+// Persist atomically replaces the persisted hard state with the current one,
+// no message depending on it may be sent unless it succeeds
+func (serverState *ServerState) Persist() error {
 	persistenceLog := serverState.Name + "," + strconv.Itoa(serverState.CurrentTerm) + "," + serverState.VotedFor + "," + strconv.Itoa(serverState.CommitLength)
-	err := logger.PersistServerState(persistenceLog)
-	if err != nil {
-		fmt.Println(err)
-	}
+	return persist.PersistServerState(persistenceLog)
 }
 
 func GetExistingServerStateOrCreateNew(name string) *ServerState {
-	log, err := logger.GetLatestServerStateIfPresent(name)
+	log, err := persist.GetLatestServerStateIfPresent(name)
 	if err != nil {
 		return newServerState(name)
 	}
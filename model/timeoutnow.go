@@ -0,0 +1,35 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+// TimeoutNow is sent by a leader handing its leadership over to an up to date
+// follower, which starts an election right away
+type TimeoutNow struct {
+	LeaderId    string
+	CurrentTerm int
+}
+
+func (t *TimeoutNow) String() string {
+	return "TimeoutNow" + "|" + t.LeaderId + "|" + strconv.Itoa(t.CurrentTerm)
+}
+
+func ParseTimeoutNow(message string) (*TimeoutNow, error) {
+	splits := strings.Split(message, "|")
+	var err error
+	_, err = strconv.Atoi(splits[2])
+	if err != nil {
+		return nil, err
+	}
+	currentTerm, _ := strconv.Atoi(splits[2])
+	return NewTimeoutNow(splits[1], currentTerm), nil
+}
+
+func NewTimeoutNow(leaderId string, currentTerm int) *TimeoutNow {
+	return &TimeoutNow{
+		LeaderId:    leaderId,
+		CurrentTerm: currentTerm,
+	}
+}
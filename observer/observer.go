@@ -0,0 +1,206 @@
+// Package observer hands the raft events of a node to the parts of an
+// application that need to react to them, such as background work that must
+// only run on the leader. Subscribers get the events on a channel or have a
+// callback called for each of them, in the order the node emitted them.
+//
+// A node emits its events on an Observer of its own and streams them on
+// /v1/events. The node is a program rather than a library that can be embedded,
+// so an application feeds the stream into an Observer of its own with
+// client.Observe and subscribes to that one:
+//
+//	events := observer.New()
+//	events.OnEvent(func(e observer.Event) {
+//		if e.LeaderId == e.NodeId {
+//			startLeaderWork()
+//		} else {
+//			stopLeaderWork()
+//		}
+//	}, observer.LeaderElected, observer.SteppedDown)
+//	go c.Observe(ctx, "127.0.0.1:8001", events)
+package observer
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType tells what happened to the node
+type EventType string
+
+const (
+	// LeaderElected is emitted when the node learns of a new leader, LeaderId
+	// is the node itself when it has just won an election
+	LeaderElected EventType = "LeaderElected"
+	// SteppedDown is emitted when the node stops being the leader
+	SteppedDown EventType = "SteppedDown"
+	// TermChanged is emitted when the node moves to a new term
+	TermChanged EventType = "TermChanged"
+	// PeerUnreachable is emitted when a message to Peer fails
+	PeerUnreachable EventType = "PeerUnreachable"
+	// PeerRecovered is emitted when Peer answers again after being unreachable
+	PeerRecovered EventType = "PeerRecovered"
+	// SnapshotTaken is emitted when a snapshot of the state machine is taken at
+	// Index
+	SnapshotTaken EventType = "SnapshotTaken"
+)
+
+// subscriptionBufferSize is the number of events a channel subscriber can fall
+// behind by before it's dropped
+const subscriptionBufferSize = 64
+
+// Event is something that happened to the node in Term
+type Event struct {
+	Type     EventType `json:"type"`
+	NodeId   string    `json:"node_id"`
+	Term     int       `json:"term"`
+	Role     string    `json:"role"`
+	LeaderId string    `json:"leader_id,omitempty"`
+	Peer     string    `json:"peer,omitempty"`
+	Index    int       `json:"index,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Subscription receives the events of the types it was created for, all of
+// them if none was given. Events is closed when the subscription is cancelled
+// or falls too far behind, so that a slow subscriber never blocks the node.
+// The events of a callback are queued instead, however far it falls behind,
+// so it sees every event until it's cancelled
+type Subscription struct {
+	types  map[EventType]bool
+	events chan Event
+
+	// callbacks only, wake is signalled when an event is queued and closed
+	// when the subscription is cancelled
+	queueMu   sync.Mutex
+	queue     []Event
+	cancelled bool
+	wake      chan struct{}
+}
+
+// Events returns the channel of the subscription, nil for a callback
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// deliver hands an event to the subscriber, it returns false if the
+// subscriber's channel is full
+func (s *Subscription) deliver(event Event) bool {
+	if s.wake == nil {
+		select {
+		case s.events <- event:
+			return true
+		default:
+			return false
+		}
+	}
+	s.queueMu.Lock()
+	s.queue = append(s.queue, event)
+	s.queueMu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// next takes the oldest queued event of a callback
+func (s *Subscription) next() (Event, bool) {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	if s.cancelled || len(s.queue) == 0 {
+		return Event{}, false
+	}
+	event := s.queue[0]
+	s.queue = s.queue[1:]
+	return event, true
+}
+
+func (s *Subscription) close() {
+	if s.wake == nil {
+		close(s.events)
+		return
+	}
+	s.queueMu.Lock()
+	s.cancelled = true
+	s.queue = nil
+	s.queueMu.Unlock()
+	close(s.wake)
+}
+
+func (s *Subscription) matches(event Event) bool {
+	return len(s.types) == 0 || s.types[event.Type]
+}
+
+// Observer keeps the subscriptions of a node
+type Observer struct {
+	mu            sync.Mutex
+	subscriptions map[*Subscription]struct{}
+}
+
+func New() *Observer {
+	return &Observer{subscriptions: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers a subscription to the events of types, or to every event
+// if no type is given
+func (o *Observer) Subscribe(types ...EventType) *Subscription {
+	s := &Subscription{events: make(chan Event, subscriptionBufferSize)}
+	o.add(s, types)
+	return s
+}
+
+// OnEvent calls fn for each event of types, or for every event if no type is
+// given, until the returned subscription is cancelled. fn is called from its
+// own goroutine, one event after the other, and is never dropped for being slow
+func (o *Observer) OnEvent(fn func(Event), types ...EventType) *Subscription {
+	s := &Subscription{wake: make(chan struct{}, 1)}
+	o.add(s, types)
+	go func() {
+		for range s.wake {
+			for event, ok := s.next(); ok; event, ok = s.next() {
+				fn(event)
+			}
+		}
+	}()
+	return s
+}
+
+func (o *Observer) add(s *Subscription, types []EventType) {
+	s.types = make(map[EventType]bool)
+	for _, t := range types {
+		s.types[t] = true
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.subscriptions[s] = struct{}{}
+}
+
+// Unsubscribe cancels a subscription and closes its channel, a callback isn't
+// called with the events still queued
+func (o *Observer) Unsubscribe(s *Subscription) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.subscriptions[s]; ok {
+		delete(o.subscriptions, s)
+		s.close()
+	}
+}
+
+// Emit hands an event to the matching subscriptions, it never blocks
+func (o *Observer) Emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for s := range o.subscriptions {
+		if !s.matches(event) {
+			continue
+		}
+		if !s.deliver(event) {
+			// the subscriber isn't keeping up, drop it rather than block the node
+			delete(o.subscriptions, s)
+			s.close()
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package observer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowChannelSubscriberIsDropped(t *testing.T) {
+	o := New()
+	s := o.Subscribe()
+	for i := 0; i <= subscriptionBufferSize; i++ {
+		o.Emit(Event{Type: TermChanged, Term: i})
+	}
+	received := 0
+	for range s.Events() {
+		received++
+	}
+	if received != subscriptionBufferSize {
+		t.Fatalf("received %d events before the channel was closed, want %d", received, subscriptionBufferSize)
+	}
+}
+
+func TestSlowCallbackSeesEveryEvent(t *testing.T) {
+	o := New()
+	release := make(chan struct{})
+	terms := make(chan int, 4*subscriptionBufferSize)
+	s := o.OnEvent(func(e Event) {
+		<-release
+		terms <- e.Term
+	}, TermChanged)
+	defer o.Unsubscribe(s)
+
+	const events = 4 * subscriptionBufferSize
+	for i := 0; i < events; i++ {
+		o.Emit(Event{Type: TermChanged, Term: i})
+		o.Emit(Event{Type: PeerUnreachable, Peer: "n2"})
+	}
+	close(release)
+	for i := 0; i < events; i++ {
+		select {
+		case term := <-terms:
+			if term != i {
+				t.Fatalf("event %d has term %d, want the events in order", i, term)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("callback got %d of %d events", i, events)
+		}
+	}
+}
+
+func TestUnsubscribeStopsCallback(t *testing.T) {
+	o := New()
+	called := make(chan Event, 1)
+	s := o.OnEvent(func(e Event) { called <- e })
+	o.Unsubscribe(s)
+	o.Emit(Event{Type: SteppedDown})
+	select {
+	case e := <-called:
+		t.Fatalf("callback called with %+v after Unsubscribe", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
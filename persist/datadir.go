@@ -0,0 +1,252 @@
+package persist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A data directory holds every persisted file of one node:
+//
+//	meta.json      format version, node ID and cluster ID
+//	LOCK           locked while a process has the directory open
+//	members.txt    registry of the cluster members
+//	state.txt      hard state, the term, the vote and the commit length
+//	wal/log.txt    replicated log
+//	snap/          state machine files of the disk storage engine
+//
+// The format version is bumped whenever the layout changes, opening a
+// directory of an older version migrates it in place
+
+// FormatVersion is the layout version of the data directories this build writes
+const FormatVersion = 1
+
+const (
+	metaFileName = "meta.json"
+	lockFileName = "LOCK"
+
+	// LogFileName is the replicated log in the data directory
+	LogFileName = "wal/log.txt"
+	// SnapshotDir is where the state machine is persisted in the data directory
+	SnapshotDir = "snap"
+)
+
+// Metadata identifies the node and the cluster a data directory belongs to
+type Metadata struct {
+	FormatVersion int    `json:"format_version"`
+	NodeId        string `json:"node_id"`
+	ClusterId     string `json:"cluster_id"`
+}
+
+// migrations[v] brings a directory of format version v to version v+1
+var migrations = []func(dir string, nodeId string) error{
+	migrateFlatLayout,
+}
+
+// dataDirLock is held until the process exits
+var dataDirLock *os.File
+
+// clusterId is the cluster ID of the metadata of the data directory
+var (
+	clusterIdMu sync.Mutex
+	clusterId   string
+)
+
+// OpenDataDir locks dir, creating it if needed, and makes it the directory of
+// the persisted files. A new directory gets newClusterId, empty until the node
+// learns the ID of its cluster, see SetClusterId. An existing one must belong
+// to nodeId and is migrated to FormatVersion if it's older
+func OpenDataDir(dir string, nodeId string, newClusterId string) (Metadata, error) {
+	var meta Metadata
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return meta, err
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return meta, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return meta, fmt.Errorf("data directory %s is in use by another process", dir)
+	}
+
+	meta, err = readMetadata(dir)
+	if os.IsNotExist(err) {
+		// directories written before metadata existed are version 0
+		meta = Metadata{FormatVersion: 0, NodeId: nodeId, ClusterId: newClusterId}
+	} else if err != nil {
+		lock.Close()
+		return meta, err
+	}
+	if meta.NodeId != nodeId {
+		lock.Close()
+		return meta, fmt.Errorf("data directory %s belongs to node %s", dir, meta.NodeId)
+	}
+	if meta.FormatVersion > FormatVersion {
+		lock.Close()
+		return meta, fmt.Errorf("data directory %s has format version %d, this build supports up to %d", dir, meta.FormatVersion, FormatVersion)
+	}
+	for meta.FormatVersion < FormatVersion {
+		if err := migrations[meta.FormatVersion](dir, nodeId); err != nil {
+			lock.Close()
+			return meta, fmt.Errorf("migrating data directory %s from format version %d: %v", dir, meta.FormatVersion, err)
+		}
+		meta.FormatVersion++
+	}
+	for _, sub := range []string{filepath.Dir(LogFileName), SnapshotDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			lock.Close()
+			return meta, err
+		}
+	}
+	if err := writeMetadata(dir, meta); err != nil {
+		lock.Close()
+		return meta, err
+	}
+	dataDir = dir
+	dataDirLock = lock
+	clusterIdMu.Lock()
+	clusterId = meta.ClusterId
+	clusterIdMu.Unlock()
+	return meta, nil
+}
+
+// ClusterId returns the ID of the cluster the node belongs to, "" until the
+// node learns it
+func ClusterId() string {
+	clusterIdMu.Lock()
+	defer clusterIdMu.Unlock()
+	return clusterId
+}
+
+// SetClusterId records the ID of the cluster the node belongs to in its metadata
+func SetClusterId(id string) error {
+	clusterIdMu.Lock()
+	defer clusterIdMu.Unlock()
+	meta, err := readMetadata(dataDir)
+	if err != nil {
+		return err
+	}
+	meta.ClusterId = id
+	if err := writeMetadata(dataDir, meta); err != nil {
+		return err
+	}
+	clusterId = id
+	return nil
+}
+
+func readMetadata(dir string) (Metadata, error) {
+	var meta Metadata
+	data, err := ioutil.ReadFile(filepath.Join(dir, metaFileName))
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, errors.New("corrupt metadata file in " + dir)
+	}
+	return meta, nil
+}
+
+// writeMetadata replaces the metadata file atomically
+func writeMetadata(dir string, meta Metadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, metaFileName), append(data, '\n'))
+}
+
+// writeFileAtomic replaces the file at path with data so that a crash leaves
+// either the old or the new content: data is written to a temporary file
+// which is synced and renamed over path, then the directory is synced
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// migrateFlatLayout moves the files of version 0, which were named after the
+// node and kept next to each other, to their places in version 1
+func migrateFlatLayout(dir string, nodeId string) error {
+	if err := migrateServersFile(dir); err != nil {
+		return err
+	}
+	moves := [][2]string{
+		{"server-state.txt", serverStateFileName},
+		{nodeId + ".txt", LogFileName},
+	}
+	for _, move := range moves {
+		from, to := filepath.Join(dir, move[0]), filepath.Join(dir, move[1])
+		if _, err := os.Stat(from); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateServersFile rewrites the member registry of version 0, whose lines
+// are "name,port" with the port served on localhost to both peers and
+// clients, as the registry of version 1 with a peer and a client address
+func migrateServersFile(dir string) error {
+	from := filepath.Join(dir, "all-servers.txt")
+	data, err := ioutil.ReadFile(from)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var members strings.Builder
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		splits := strings.Split(line, ",")
+		if len(splits) != 2 || splits[0] == "" {
+			return fmt.Errorf("unexpected member %q in %s", line, from)
+		}
+		if port, err := strconv.Atoi(splits[1]); err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port of member %s in %s", splits[0], from)
+		}
+		addr := "localhost:" + splits[1]
+		members.WriteString(splits[0] + "," + addr + "," + addr + "\n")
+	}
+	if err := writeFileAtomic(filepath.Join(dir, serversFileName), []byte(members.String())); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
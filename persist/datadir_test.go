@@ -0,0 +1,102 @@
+package persist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openDataDir opens dir for the test and releases its lock when the test ends
+func openDataDir(t *testing.T, dir string, nodeId string) (Metadata, error) {
+	t.Helper()
+	meta, err := OpenDataDir(dir, nodeId, "c1")
+	if err == nil {
+		lock := dataDirLock
+		t.Cleanup(func() { lock.Close() })
+	}
+	return meta, err
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrateFlatLayout(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"all-servers.txt":  "n1,8001\nn2,8002\n",
+		"server-state.txt": "n1,3,n2,5\n",
+		"n1.txt":           "SET a 1#1\n",
+	})
+
+	meta, err := openDataDir(t, dir, "n1")
+	if err != nil {
+		t.Fatalf("OpenDataDir: %v", err)
+	}
+	if meta.FormatVersion != FormatVersion || meta.NodeId != "n1" {
+		t.Fatalf("metadata = %+v", meta)
+	}
+	peers, _ := ListAllServers()
+	clients, _ := ListClientAddrs()
+	if len(peers) != 2 || peers["n2"] != "localhost:8002" || clients["n1"] != "localhost:8001" {
+		t.Fatalf("members after migration: peers %v, clients %v", peers, clients)
+	}
+	for name, content := range map[string]string{serverStateFileName: "n1,3,n2,5\n", LogFileName: "SET a 1#1\n"} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, content)
+		}
+	}
+	for _, name := range []string{"all-servers.txt", "server-state.txt", "n1.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s of version 0 is left after the migration", name)
+		}
+	}
+}
+
+func TestMigrateFlatLayoutRejectsUnknownMembers(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"all-servers.txt": "n1,8001\nn2\n"})
+	if _, err := openDataDir(t, dir, "n1"); err == nil {
+		t.Fatal("OpenDataDir migrated a registry it can't read")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "all-servers.txt")); err != nil {
+		t.Fatalf("registry of version 0 is gone after a failed migration: %v", err)
+	}
+}
+
+func TestOpenDataDirOfAnotherNode(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := openDataDir(t, dir, "n1"); err != nil {
+		t.Fatal(err)
+	}
+	dataDirLock.Close()
+	if _, err := openDataDir(t, dir, "n2"); err == nil {
+		t.Fatal("n2 opened the data directory of n1")
+	}
+}
+
+func TestClusterIdSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenDataDir(dir, "n1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if ClusterId() != "" {
+		t.Fatalf("cluster ID of a joining node = %q, want none", ClusterId())
+	}
+	if err := SetClusterId("c2"); err != nil {
+		t.Fatal(err)
+	}
+	dataDirLock.Close()
+	meta, err := openDataDir(t, dir, "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ClusterId != "c2" || ClusterId() != "c2" {
+		t.Fatalf("cluster ID after restart = %q, %q, want c2", meta.ClusterId, ClusterId())
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package persist
+
+import "os"
+
+// lockFile doesn't lock anything on platforms without flock
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package persist
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on f, released when the process exits
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
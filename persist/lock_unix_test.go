@@ -0,0 +1,15 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package persist
+
+import "testing"
+
+func TestDataDirLocked(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := openDataDir(t, dir, "n1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openDataDir(t, dir, "n1"); err == nil {
+		t.Fatal("data directory opened twice")
+	}
+}
@@ -0,0 +1,92 @@
+// Package persist keeps the files of a node in its data directory: the member
+// registry, the hard state and the metadata of the directory itself
+package persist
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/ssergomol/raft/utils"
+)
+
+const serversFileName string = "members.txt"
+const serverStateFileName string = "state.txt"
+
+// dataDir is the directory every persisted file of the node lives in, see OpenDataDir
+var dataDir = "."
+
+// DataPath returns the path of a persisted file in the data directory
+func DataPath(fileName string) string {
+	return filepath.Join(dataDir, fileName)
+}
+
+// AddServer registers a server together with the address it serves raft peers
+// on and the one it serves clients on, registering it again with the same
+// addresses is a no-op
+func AddServer(serverName string, peerAddr string, clientAddr string) error {
+	peerAddrs, _ := ListAllServers()
+	clientAddrs, _ := ListClientAddrs()
+	if peerAddrs[serverName] == peerAddr && clientAddrs[serverName] == clientAddr {
+		return nil
+	}
+	var err = utils.CreateFileIfNotExists(DataPath(serversFileName))
+	if err != nil {
+		return err
+	}
+	registryLog := serverName + "," + peerAddr + "," + clientAddr + "\n"
+	err = utils.WriteToFile(DataPath(serversFileName), registryLog)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListAllServers returns the peer address of every registered server
+func ListAllServers() (map[string]string, error) {
+	return listServerAddrs(1)
+}
+
+// ListClientAddrs returns the client address of every registered server
+func ListClientAddrs() (map[string]string, error) {
+	return listServerAddrs(2)
+}
+
+func listServerAddrs(field int) (map[string]string, error) {
+	m := make(map[string]string)
+	registeryLines, err := utils.ReadFile(DataPath(serversFileName))
+	if err != nil {
+		return m, err
+	}
+	for _, line := range registeryLines {
+		splits := strings.Split(line, ",")
+		if len(splits) != 3 {
+			continue
+		}
+		m[splits[0]] = splits[field]
+	}
+	return m, nil
+}
+
+// PersistServerState atomically replaces the hard state file with serverStateLog
+func PersistServerState(serverStateLog string) error {
+	return writeFileAtomic(DataPath(serverStateFileName), []byte(serverStateLog+"\n"))
+}
+
+func GetLatestServerStateIfPresent(serverName string) (string, error) {
+	serverStateLogs, err := utils.ReadFile(DataPath(serverStateFileName))
+	var serverStateLog = ""
+	if err != nil {
+		return "", err
+	}
+	for _, line := range serverStateLogs {
+		splits := strings.Split(line, ",")
+		if splits[0] == serverName {
+			serverStateLog = line
+		}
+	}
+	if serverStateLog == "" {
+		return "", errors.New("no existing server state found")
+	}
+	return serverStateLog, nil
+}
@@ -0,0 +1,530 @@
+// raftctl is a non-interactive command line client of the key value store:
+//
+//	raftctl [global flags] get [-rev N] KEY
+//	raftctl [global flags] put [-ttl SECONDS] [-prev-value V | -if-absent] KEY VALUE
+//	raftctl [global flags] del [-prev-value V] KEY
+//	raftctl [global flags] watch [-prefix] [-from INDEX] KEY
+//	raftctl [global flags] events [-type TYPE,...] [ENDPOINT]
+//	raftctl [global flags] txn [FILE]
+//	raftctl [global flags] member list
+//	raftctl [global flags] member add NAME PEER_ADDR CLIENT_ADDR
+//	raftctl [global flags] leader transfer NAME
+//	raftctl [global flags] snapshot save FILE
+//
+// With -batch FILE the commands are read from FILE, one per line, and run in
+// order until one fails. Blank lines and lines starting with # are skipped.
+// The exit code tells scripts how the (first failing) command ended
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ssergomol/raft/client"
+	"github.com/ssergomol/raft/database"
+	"github.com/ssergomol/raft/observer"
+)
+
+// Exit codes of raftctl
+const (
+	ExitOK              = 0
+	ExitError           = 1
+	ExitUsage           = 2
+	ExitNotFound        = 3
+	ExitConditionFailed = 4
+	ExitUnavailable     = 5
+	ExitUnauthorized    = 6
+)
+
+const usage = `usage: raftctl [global flags] COMMAND [flags] [args]
+
+commands:
+  get [-rev N] KEY
+  put [-ttl SECONDS] [-prev-value V | -if-absent] KEY VALUE
+  del [-prev-value V] KEY
+  watch [-prefix] [-from INDEX] KEY
+  events [-type TYPE,...] [ENDPOINT] raft events of one node, of the first endpoint without ENDPOINT
+  txn [FILE]                       transaction as JSON, read from stdin without FILE
+  member list
+  member add NAME PEER_ADDR CLIENT_ADDR
+  leader transfer NAME
+  snapshot save FILE
+
+global flags:
+`
+
+// usageError is a command raftctl can't make sense of
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func usagef(format string, args ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+func exitCode(err error) int {
+	var usageErr *usageError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &usageErr):
+		return ExitUsage
+	case errors.Is(err, client.ErrKeyNotFound):
+		return ExitNotFound
+	case errors.Is(err, client.ErrConditionFailed):
+		return ExitConditionFailed
+	case errors.Is(err, client.ErrNoLeader), errors.Is(err, context.DeadlineExceeded):
+		return ExitUnavailable
+	case errors.Is(err, client.ErrUnauthorized):
+		return ExitUnauthorized
+	}
+	return ExitError
+}
+
+// cli runs the commands against one client
+type cli struct {
+	client  *client.Client
+	output  string
+	timeout time.Duration
+	stdin   io.Reader
+	stdout  io.Writer
+}
+
+func main() {
+	global := flag.NewFlagSet("raftctl", flag.ContinueOnError)
+	global.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		global.PrintDefaults()
+	}
+	endpoints := global.String("endpoints", os.Getenv("RAFTCTL_ENDPOINTS"), "comma separated client addresses of the servers, $RAFTCTL_ENDPOINTS by default")
+	output := global.String("output", "plain", "output format, plain or json")
+	timeout := global.Duration("timeout", 10*time.Second, "timeout of every command including its retries, watches aren't bounded")
+	batch := global.String("batch", "", "file to read commands from, one per line, - for stdin")
+	caCertFile := global.String("cacert", "", "CA certificates the servers are verified with, enables TLS")
+	certFile := global.String("cert", "", "client certificate for servers that require one")
+	keyFile := global.String("key", "", "key of the client certificate")
+	user := global.String("user", "", "name:password to authenticate with")
+	if err := global.Parse(os.Args[1:]); err != nil {
+		os.Exit(ExitUsage)
+	}
+
+	if *output != "plain" && *output != "json" {
+		fail(usagef("output must be plain or json"))
+	}
+	if *endpoints == "" {
+		fail(usagef("need -endpoints or RAFTCTL_ENDPOINTS"))
+	}
+	config := client.Config{Endpoints: strings.Split(*endpoints, ",")}
+	var err error
+	if config.TLS, err = tlsConfig(*caCertFile, *certFile, *keyFile); err != nil {
+		fail(usagef("%v", err))
+	}
+	if *user != "" {
+		splits := strings.SplitN(*user, ":", 2)
+		if len(splits) != 2 {
+			fail(usagef("-user must be name:password"))
+		}
+		config.Username, config.Password = splits[0], splits[1]
+	}
+	c, err := client.New(config)
+	if err != nil {
+		fail(usagef("%v", err))
+	}
+	ctl := &cli{client: c, output: *output, timeout: *timeout, stdin: os.Stdin, stdout: os.Stdout}
+
+	if *batch != "" {
+		if global.NArg() > 0 {
+			fail(usagef("-batch doesn't take a command"))
+		}
+		fail(ctl.runBatch(*batch))
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(ExitUsage)
+	}
+	fail(ctl.run(global.Args()))
+}
+
+// fail exits with the code of err, printing it unless it's nil
+func fail(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	os.Exit(exitCode(err))
+}
+
+func tlsConfig(caCertFile string, certFile string, keyFile string) (*tls.Config, error) {
+	if caCertFile == "" {
+		if certFile != "" || keyFile != "" {
+			return nil, errors.New("a client certificate needs -cacert")
+		}
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(caCertFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in " + caCertFile)
+	}
+	config := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// runBatch runs the commands of a file until one fails
+func (c *cli) runBatch(file string) error {
+	in := c.stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return usagef("%v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	scanner := bufio.NewScanner(in)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := c.run(strings.Fields(text)); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (c *cli) run(args []string) error {
+	switch args[0] {
+	case "get":
+		return c.get(args[1:])
+	case "put":
+		return c.put(args[1:])
+	case "del":
+		return c.del(args[1:])
+	case "watch":
+		return c.watch(args[1:])
+	case "events":
+		return c.events(args[1:])
+	case "txn":
+		return c.txn(args[1:])
+	case "member":
+		if len(args) > 1 && args[1] == "list" {
+			return c.memberList(args[2:])
+		}
+		if len(args) > 1 && args[1] == "add" {
+			return c.memberAdd(args[2:])
+		}
+		return usagef("member needs list or add")
+	case "leader":
+		if len(args) == 3 && args[1] == "transfer" {
+			return c.leaderTransfer(args[2])
+		}
+		return usagef("usage: leader transfer NAME")
+	case "snapshot":
+		if len(args) == 3 && args[1] == "save" {
+			return c.snapshotSave(args[2])
+		}
+		return usagef("usage: snapshot save FILE")
+	}
+	return usagef("unknown command %s", args[0])
+}
+
+// parse parses the flags of a command and checks its number of arguments
+func parse(fs *flag.FlagSet, args []string, nargs int, usage string) error {
+	fs.SetOutput(ioutil.Discard)
+	if err := fs.Parse(args); err != nil {
+		return usagef("%v, usage: %s", err, usage)
+	}
+	if fs.NArg() != nargs {
+		return usagef("usage: %s", usage)
+	}
+	return nil
+}
+
+// print writes the plain text or, with -output json, v as JSON
+func (c *cli) print(plain string, v interface{}) {
+	if c.output == "json" {
+		json.NewEncoder(c.stdout).Encode(v)
+		return
+	}
+	fmt.Fprintln(c.stdout, plain)
+}
+
+func (c *cli) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+func (c *cli) get(args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	rev := fs.Int("rev", 0, "revision to read the key at")
+	if err := parse(fs, args, 1, "get [-rev N] KEY"); err != nil {
+		return err
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+
+	var kv database.KeyValue
+	var err error
+	if *rev > 0 {
+		kv, err = c.client.GetAt(ctx, fs.Arg(0), *rev)
+	} else {
+		kv, err = c.client.Get(ctx, fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	c.print(strconv.Itoa(kv.Value), kv)
+	return nil
+}
+
+func (c *cli) put(args []string) error {
+	fs := flag.NewFlagSet("put", flag.ContinueOnError)
+	ttl := fs.Int("ttl", 0, "seconds until the key expires")
+	prevValue := fs.String("prev-value", "", "only put if the current value is this one")
+	ifAbsent := fs.Bool("if-absent", false, "only put if the key doesn't exist")
+	if err := parse(fs, args, 2, "put [-ttl SECONDS] [-prev-value V | -if-absent] KEY VALUE"); err != nil {
+		return err
+	}
+	value, err := strconv.Atoi(fs.Arg(1))
+	if err != nil {
+		return usagef("not a valid integer value %s", fs.Arg(1))
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+
+	var kv database.KeyValue
+	switch {
+	case *prevValue != "" && (*ifAbsent || *ttl != 0):
+		return usagef("-prev-value can't be used with -if-absent or -ttl")
+	case *prevValue != "":
+		prev, err := strconv.Atoi(*prevValue)
+		if err != nil {
+			return usagef("not a valid integer value %s", *prevValue)
+		}
+		kv, err = c.client.CAS(ctx, fs.Arg(0), prev, value)
+		if err != nil {
+			return err
+		}
+	case *ifAbsent && *ttl != 0:
+		return usagef("-if-absent can't be used with -ttl")
+	case *ifAbsent:
+		kv, err = c.client.SetNX(ctx, fs.Arg(0), value)
+	case *ttl != 0:
+		kv, err = c.client.SetWithTTL(ctx, fs.Arg(0), value, time.Duration(*ttl)*time.Second)
+	default:
+		kv, err = c.client.Set(ctx, fs.Arg(0), value)
+	}
+	if err != nil {
+		return err
+	}
+	c.print("OK", kv)
+	return nil
+}
+
+func (c *cli) del(args []string) error {
+	fs := flag.NewFlagSet("del", flag.ContinueOnError)
+	prevValue := fs.String("prev-value", "", "only delete if the current value is this one")
+	if err := parse(fs, args, 1, "del [-prev-value V] KEY"); err != nil {
+		return err
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+
+	var err error
+	if *prevValue != "" {
+		prev, convErr := strconv.Atoi(*prevValue)
+		if convErr != nil {
+			return usagef("not a valid integer value %s", *prevValue)
+		}
+		err = c.client.DeleteIfEqual(ctx, fs.Arg(0), prev)
+	} else {
+		err = c.client.Delete(ctx, fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	c.print("OK", map[string]interface{}{"key": fs.Arg(0), "deleted": true})
+	return nil
+}
+
+func (c *cli) watch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	prefix := fs.Bool("prefix", false, "watch every key starting with KEY")
+	from := fs.Int("from", 0, "index to resume the watch from")
+	if err := parse(fs, args, 1, "watch [-prefix] [-from INDEX] KEY"); err != nil {
+		return err
+	}
+	return c.client.Watch(context.Background(), fs.Arg(0), *prefix, *from, func(event database.Event) error {
+		plain := event.Type + " " + event.Key
+		if event.Type == "SET" {
+			plain += " " + strconv.Itoa(event.Value)
+		}
+		c.print(plain+" "+strconv.Itoa(event.Index), event)
+		return nil
+	})
+}
+
+func (c *cli) events(args []string) error {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	typeList := fs.String("type", "", "comma separated event types to follow, all of them by default")
+	fs.SetOutput(ioutil.Discard)
+	if err := fs.Parse(args); err != nil || fs.NArg() > 1 {
+		return usagef("usage: events [-type TYPE,...] [ENDPOINT]")
+	}
+	var types []observer.EventType
+	if *typeList != "" {
+		for _, t := range strings.Split(*typeList, ",") {
+			types = append(types, observer.EventType(t))
+		}
+	}
+	return c.client.Events(context.Background(), fs.Arg(0), types, func(event observer.Event) error {
+		plain := string(event.Type) + " " + event.NodeId + " term " + strconv.Itoa(event.Term)
+		switch {
+		case event.Peer != "":
+			plain += " peer " + event.Peer
+		case event.Type == observer.SnapshotTaken:
+			plain += " index " + strconv.Itoa(event.Index)
+		case event.LeaderId != "":
+			plain += " leader " + event.LeaderId
+		}
+		c.print(plain, event)
+		return nil
+	})
+}
+
+func (c *cli) txn(args []string) error {
+	if len(args) > 1 {
+		return usagef("usage: txn [FILE]")
+	}
+	var data []byte
+	var err error
+	if len(args) == 1 && args[0] != "-" {
+		data, err = ioutil.ReadFile(args[0])
+	} else {
+		data, err = ioutil.ReadAll(c.stdin)
+	}
+	if err != nil {
+		return err
+	}
+	txn, err := database.ParseTxn(data)
+	if err != nil {
+		return usagef("%v", err)
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+
+	res, err := c.client.Txn(ctx, *txn)
+	if err != nil {
+		return err
+	}
+	plain := "FAILURE"
+	if res.Succeeded {
+		plain = "SUCCESS"
+	}
+	c.print(plain+" "+strconv.Itoa(res.Revision), res)
+	return nil
+}
+
+func (c *cli) memberList(args []string) error {
+	if len(args) != 0 {
+		return usagef("usage: member list")
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+
+	members, err := c.client.MemberList(ctx)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		c.print("", members)
+		return nil
+	}
+	for _, m := range members {
+		line := m.Name + " " + m.PeerAddr + " " + m.ClientAddr
+		if m.Leader {
+			line += " leader"
+		}
+		fmt.Fprintln(c.stdout, line)
+	}
+	return nil
+}
+
+func (c *cli) memberAdd(args []string) error {
+	if len(args) != 3 {
+		return usagef("usage: member add NAME PEER_ADDR CLIENT_ADDR")
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+
+	m := client.Member{Name: args[0], PeerAddr: args[1], ClientAddr: args[2]}
+	if err := c.client.MemberAdd(ctx, m); err != nil {
+		return err
+	}
+	c.print("Added member "+m.Name, m)
+	return nil
+}
+
+func (c *cli) leaderTransfer(target string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	if err := c.client.TransferLeadership(ctx, target); err != nil {
+		return err
+	}
+	c.print("Leadership transfer to "+target+" started", map[string]string{"target": target})
+	return nil
+}
+
+func (c *cli) snapshotSave(file string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	// the snapshot is written next to the file and renamed once complete
+	tmp := file + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = c.client.SaveSnapshot(ctx, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	c.print("Snapshot saved to "+file, map[string]string{"file": file})
+	return nil
+}
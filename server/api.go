@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ssergomol/raft/database"
+	"github.com/ssergomol/raft/persist"
+)
+
+// The v1 API is a JSON REST API over the key value store:
+//
+//	GET    /v1/kv/{key}[?rev=index|?history=true]
+//	PUT    /v1/kv/{key}  {"value": 1, "ttl": 10, "prev_value": 0, "prev_exist": false}
+//	DELETE /v1/kv/{key}[?prev_value=value]
+//	GET    /v1/kv/?prefix=|start=&end=[&limit=&token=&count_only=true]
+//	POST   /v1/txn
+//	GET    /v1/watch/{key}[?prefix=true&from=index]
+//
+// Missing keys are answered with 404, failed conditions with 409 and writes
+// that can't reach a leader with 503 together with the last known leader. With
+// -follower-mode=redirect followers answer writes with a 307 to the leader
+
+// apiResponse is the body of every v1 response but transactions, scans and watches
+type apiResponse struct {
+	Error      string `json:"error,omitempty"`
+	Key        string `json:"key,omitempty"`
+	Value      *int   `json:"value,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	TTL        int64  `json:"ttl,omitempty"`
+	LeaderId   string `json:"leader_id,omitempty"`
+	LeaderAddr string `json:"leader_addr,omitempty"`
+}
+
+// putRequest is the body of a PUT, prev_value turns it into a CAS and
+// prev_exist=false into a SETNX
+type putRequest struct {
+	Value     *int  `json:"value"`
+	TTL       int   `json:"ttl"`
+	PrevValue *int  `json:"prev_value"`
+	PrevExist *bool `json:"prev_exist"`
+}
+
+func registerV1Routes(mux *http.ServeMux, s *Server) {
+	mux.HandleFunc("/v1/kv/", s.handleV1KV)
+	mux.HandleFunc("/v1/txn", s.handleV1Txn)
+	mux.HandleFunc("/v1/watch/", s.handleWatch)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiResponse{Error: message})
+}
+
+func resultStatusCode(status database.Status) int {
+	switch status {
+	case database.StatusOK:
+		return http.StatusOK
+	case database.StatusNotFound:
+		return http.StatusNotFound
+	case database.StatusConditionFailed:
+		return http.StatusConflict
+	case database.StatusInvalid:
+		return http.StatusBadRequest
+	case database.StatusUnavailable:
+		return http.StatusServiceUnavailable
+	case database.StatusStaleSequence:
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeResult(w http.ResponseWriter, result database.Result, deleted bool) {
+	res := apiResponse{Key: result.Key, Version: result.Version}
+	if result.Status != database.StatusOK {
+		res.Error = result.Message
+	}
+	if (result.Status == database.StatusOK && !deleted) || result.Status == database.StatusConditionFailed {
+		value := result.Value
+		res.Value = &value
+	}
+	writeJSON(w, resultStatusCode(result.Status), res)
+}
+
+// leaderAddr returns the client address of the current leader, "" if there is none
+func (s *Server) leaderAddr() string {
+	if s.leaderNodeId == "" {
+		return ""
+	}
+	clientAddrs, _ := persist.ListClientAddrs()
+	return clientAddrs[s.leaderNodeId]
+}
+
+// leaderURL returns the base URL of the leader's client listener, "" if there is no leader
+func (s *Server) leaderURL() string {
+	addr := s.leaderAddr()
+	if addr == "" {
+		return ""
+	}
+	return s.tls.clientScheme() + "://" + addr
+}
+
+// withLeaderHeader sets LeaderHeader on the responses of handler
+func (s *Server) withLeaderHeader(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr := s.leaderAddr(); addr != "" {
+			w.Header().Set(LeaderHeader, addr)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// writeNotLeader answers a write that isn't served by this node together with
+// the leader it should be sent to, as JSON on the v1 API and as text otherwise
+func (s *Server) writeNotLeader(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		writeJSON(w, status, apiResponse{
+			Error:      message,
+			LeaderId:   s.leaderNodeId,
+			LeaderAddr: s.leaderAddr(),
+		})
+		return
+	}
+	if s.leaderNodeId != "" {
+		message += ", current leader: " + s.leaderNodeId + " (" + s.leaderAddr() + ")"
+	}
+	http.Error(w, message, status)
+}
+
+// redirectToLeader answers a write on a follower unless it's to be proxied: with
+// a 503 while no leader is known and, in redirect mode, with a 307 to the
+// leader. It returns false if the request should be proxied
+func (s *Server) redirectToLeader(w http.ResponseWriter, r *http.Request) bool {
+	leaderURL := s.leaderURL()
+	if leaderURL == "" {
+		s.writeNotLeader(w, r, http.StatusServiceUnavailable, "no leader is known")
+		return true
+	}
+	if *followerMode != "redirect" {
+		return false
+	}
+	w.Header().Set("Location", leaderURL+r.URL.RequestURI())
+	s.writeNotLeader(w, r, http.StatusTemporaryRedirect, "not leader")
+	return true
+}
+
+// forwardToLeader replays a v1 request on the leader and copies its response back
+func (s *Server) forwardToLeader(w http.ResponseWriter, r *http.Request, body []byte) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	s.log.Debug("forwarding to the leader", "leader", s.leaderNodeId)
+	req, err := http.NewRequest(r.Method, s.leaderURL()+r.URL.RequestURI(), bytes.NewBuffer(body))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, header := range []string{"Content-Type", "Authorization", ClientIdHeader, ClientSeqHeader, TraceparentHeader} {
+		req.Header.Set(header, r.Header.Get(header))
+	}
+	resp, err := s.tls.leaderHTTP.Do(req)
+	if err != nil {
+		s.writeNotLeader(w, r, http.StatusServiceUnavailable, "error redirecting request to the leader")
+		return
+	}
+	defer resp.Body.Close()
+
+	respData, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		s.writeNotLeader(w, r, http.StatusServiceUnavailable, "error reading response of the leader")
+		return
+	}
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respData)
+}
+
+// proposeV1 validates a write and proposes it if this node is the leader. On a
+// follower the request is forwarded to the leader, which writes the response,
+// and ok is false
+func (s *Server) proposeV1(w http.ResponseWriter, r *http.Request, body []byte, command string) (result database.Result, ok bool) {
+	command, err := sessionCommand(r, command)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return result, false
+	}
+	if err := s.db.ValidateCommand(command); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return result, false
+	}
+	if err := s.authorizeCommand(r, command); err != nil {
+		writeError(w, authStatusCode(err), err.Error())
+		return result, false
+	}
+	if s.currentRole != "leader" {
+		s.forwardToLeader(w, r, body)
+		return result, false
+	}
+	return s.proposeRequest(r, command), true
+}
+
+func (s *Server) handleV1KV(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	if key == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusBadRequest, "need a key")
+			return
+		}
+		if r.URL.Query().Get("count_only") == "true" {
+			s.handleCount(w, r)
+		} else {
+			s.handleScan(w, r)
+		}
+		return
+	}
+	if !database.ValidKey(key) {
+		writeError(w, http.StatusBadRequest, "not a valid key")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.handleV1Get(w, r, key)
+	case http.MethodPut:
+		s.handleV1Put(w, r, key)
+	case http.MethodDelete:
+		s.handleV1Delete(w, r, key)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "only GET, PUT and DELETE are supported")
+	}
+}
+
+func (s *Server) handleV1Get(w http.ResponseWriter, r *http.Request, key string) {
+	queryParams := r.URL.Query()
+	s.log.Debug("client command", "command", "GET "+key)
+	if err := s.authorizeRead(r, key); err != nil {
+		writeError(w, authStatusCode(err), err.Error())
+		return
+	}
+	if queryParams.Get("history") == "true" {
+		writeJSON(w, http.StatusOK, s.db.History(key))
+		return
+	}
+
+	rev := 0
+	if queryParams.Get("rev") != "" {
+		var err error
+		rev, err = strconv.Atoi(queryParams.Get("rev"))
+		if err != nil || rev <= 0 {
+			writeError(w, http.StatusBadRequest, "not a valid revision")
+			return
+		}
+	}
+	kv, err := s.db.Lookup(key, rev)
+	if err == database.ErrKeyNotFound {
+		writeJSON(w, http.StatusNotFound, apiResponse{Error: err.Error(), Key: key})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusGone, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, apiResponse{Key: kv.Key, Value: &kv.Value, Version: kv.Version, TTL: kv.TTL})
+}
+
+func (s *Server) handleV1Put(w http.ResponseWriter, r *http.Request, key string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "error reading request body")
+		return
+	}
+	defer r.Body.Close()
+
+	var put putRequest
+	if err := json.Unmarshal(body, &put); err != nil || put.Value == nil {
+		writeError(w, http.StatusBadRequest, "need a JSON body with an integer value")
+		return
+	}
+	value := strconv.Itoa(*put.Value)
+	command := "SET " + key + " " + value
+	switch {
+	case put.PrevValue != nil && put.PrevExist != nil:
+		writeError(w, http.StatusBadRequest, "prev_value and prev_exist can't be used together")
+		return
+	case (put.PrevValue != nil || put.PrevExist != nil) && put.TTL != 0:
+		writeError(w, http.StatusBadRequest, "ttl can't be used with a condition")
+		return
+	case put.PrevValue != nil:
+		command = "CAS " + key + " " + strconv.Itoa(*put.PrevValue) + " " + value
+	case put.PrevExist != nil && !*put.PrevExist:
+		command = "SETNX " + key + " " + value
+	case put.PrevExist != nil:
+		writeError(w, http.StatusBadRequest, "only prev_exist=false is supported")
+		return
+	case put.TTL != 0:
+		command += " EX " + strconv.Itoa(put.TTL)
+	}
+	s.log.Debug("client command", "command", command)
+
+	result, ok := s.proposeV1(w, r, body, command)
+	if ok {
+		writeResult(w, result, false)
+	}
+}
+
+func (s *Server) handleV1Delete(w http.ResponseWriter, r *http.Request, key string) {
+	command := "DELETE " + key
+	if prevValue := r.URL.Query().Get("prev_value"); prevValue != "" {
+		command = "DELIFEQ " + key + " " + prevValue
+	}
+	s.log.Debug("client command", "command", command)
+
+	result, ok := s.proposeV1(w, r, nil, command)
+	if ok {
+		writeResult(w, result, true)
+	}
+}
+
+func (s *Server) handleV1Txn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "only POST is supported for transactions")
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "error reading request body")
+		return
+	}
+	defer r.Body.Close()
+
+	txn, err := database.ParseTxn(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	command, err := txn.Command()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error encoding transaction")
+		return
+	}
+	s.log.Debug("client command", "command", "TXN", "txn", string(body))
+
+	result, ok := s.proposeV1(w, r, body, command)
+	if !ok {
+		return
+	}
+	if result.Status != database.StatusOK {
+		writeResult(w, result, false)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(result.Message + "\n"))
+}
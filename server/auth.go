@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ssergomol/raft/database"
+)
+
+// Once authentication is enabled every client request carries either a token
+// from /v1/auth/authenticate as "Authorization: Bearer <token>" or basic auth,
+// and is checked against the permissions of the user's roles before it's
+// proposed, forwarded or served. Users and roles are managed as root with
+//
+//	POST   /v1/auth/authenticate                {"name": "", "password": ""}
+//	POST   /v1/auth/enable | /v1/auth/disable
+//	GET    /v1/auth/users | /v1/auth/roles
+//	PUT    /v1/auth/users/{user}                {"password": ""}
+//	PUT    /v1/auth/users/{user}/password       {"password": ""}
+//	DELETE /v1/auth/users/{user}
+//	PUT    /v1/auth/users/{user}/roles/{role}   DELETE revokes the role
+//	PUT    /v1/auth/roles/{role}                DELETE removes the role
+//	PUT    /v1/auth/roles/{role}/permissions    {"prefix": "", "read": true, "write": false}
+//	DELETE /v1/auth/roles/{role}/permissions?prefix=
+
+var errUnknownAuthRequest = errors.New("unknown auth request")
+
+// authRequest is the body of the authenticate and user requests
+type authRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// requestUser returns the user a request is authenticated as, "" while
+// authentication is disabled
+func (s *Server) requestUser(r *http.Request) (string, error) {
+	if !s.db.AuthEnabled() {
+		return "", nil
+	}
+	if name, password, ok := r.BasicAuth(); ok {
+		if err := s.db.Authenticate(name, password); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return "", database.ErrAuthFailed
+	}
+	return s.db.VerifyToken(token, time.Now())
+}
+
+func authStatusCode(err error) int {
+	if err == database.ErrPermissionDenied {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// authorizeCommand checks that the caller may have command applied, it's done
+// before the command is proposed so denied writes never reach the log
+func (s *Server) authorizeCommand(r *http.Request, command string) error {
+	user, err := s.requestUser(r)
+	if err != nil {
+		return err
+	}
+	return s.db.AuthorizeCommand(user, command)
+}
+
+func (s *Server) authorizeRead(r *http.Request, key string) error {
+	user, err := s.requestUser(r)
+	if err != nil {
+		return err
+	}
+	return s.db.AuthorizeKey(user, key, false)
+}
+
+// authorizeRange checks that the caller may read the keys in [start, end)
+func (s *Server) authorizeRange(r *http.Request, start string, end string) error {
+	user, err := s.requestUser(r)
+	if err != nil {
+		return err
+	}
+	return s.db.AuthorizeRange(user, start, end)
+}
+
+func registerAuthRoutes(mux *http.ServeMux, s *Server) {
+	mux.HandleFunc("/v1/auth/authenticate", s.handleAuthenticate)
+	mux.HandleFunc("/v1/auth/", s.handleV1Auth)
+}
+
+func (s *Server) handleAuthenticate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "only POST is supported for authentication")
+		return
+	}
+	var req authRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "need a JSON body with a name and a password")
+		return
+	}
+	if !s.db.AuthEnabled() {
+		writeError(w, http.StatusBadRequest, "authentication is not enabled")
+		return
+	}
+	token, err := s.db.IssueToken(req.Name, req.Password, time.Now())
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"token": token})
+}
+
+// authCommand returns the AUTH command for a request on /v1/auth/
+func authCommand(r *http.Request, path []string, body []byte) (string, error) {
+	var req struct {
+		Password string `json:"password"`
+		Prefix   string `json:"prefix"`
+		Read     bool   `json:"read"`
+		Write    bool   `json:"write"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return "", err
+		}
+	}
+	put := r.Method == http.MethodPut
+	remove := r.Method == http.MethodDelete
+	switch {
+	case r.Method == http.MethodPost && len(path) == 1 && (path[0] == "enable" || path[0] == "disable"):
+		return "AUTH " + strings.ToUpper(path[0]), nil
+	case path[0] == "users" && len(path) == 2 && put:
+		return "AUTH USERADD " + path[1] + " " + req.Password, nil
+	case path[0] == "users" && len(path) == 2 && remove:
+		return "AUTH USERDEL " + path[1], nil
+	case path[0] == "users" && len(path) == 3 && path[2] == "password" && put:
+		return "AUTH PASSWD " + path[1] + " " + req.Password, nil
+	case path[0] == "users" && len(path) == 4 && path[2] == "roles" && put:
+		return "AUTH GRANTROLE " + path[1] + " " + path[3], nil
+	case path[0] == "users" && len(path) == 4 && path[2] == "roles" && remove:
+		return "AUTH REVOKEROLE " + path[1] + " " + path[3], nil
+	case path[0] == "roles" && len(path) == 2 && put:
+		return "AUTH ROLEADD " + path[1], nil
+	case path[0] == "roles" && len(path) == 2 && remove:
+		return "AUTH ROLEDEL " + path[1], nil
+	case path[0] == "roles" && len(path) == 3 && path[2] == "permissions" && put:
+		perm := ""
+		if req.Read {
+			perm += "read"
+		}
+		if req.Write {
+			perm += "write"
+		}
+		return "AUTH GRANT " + path[1] + " " + req.Prefix + " " + perm, nil
+	case path[0] == "roles" && len(path) == 3 && path[2] == "permissions" && remove:
+		return "AUTH REVOKE " + path[1] + " " + r.URL.Query().Get("prefix"), nil
+	}
+	return "", errUnknownAuthRequest
+}
+
+func (s *Server) handleV1Auth(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/auth/"), "/"), "/")
+	if r.Method == http.MethodGet && len(path) == 1 && (path[0] == "users" || path[0] == "roles") {
+		user, err := s.requestUser(r)
+		if err == nil {
+			err = s.db.AuthorizeRoot(user)
+		}
+		if err != nil {
+			writeError(w, authStatusCode(err), err.Error())
+			return
+		}
+		if path[0] == "users" {
+			writeJSON(w, http.StatusOK, s.db.Users())
+		} else {
+			writeJSON(w, http.StatusOK, s.db.Roles())
+		}
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "error reading request body")
+		return
+	}
+	defer r.Body.Close()
+
+	command, err := authCommand(r, path, body)
+	if err == errUnknownAuthRequest {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "not a valid JSON body")
+		return
+	}
+
+	result, ok := s.proposeV1(w, r, body, command)
+	if !ok {
+		return
+	}
+	if result.Status != database.StatusOK {
+		writeError(w, resultStatusCode(result.Status), result.Message)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": result.Message})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ssergomol/raft/database"
+	"github.com/ssergomol/raft/model"
+	"github.com/ssergomol/raft/observer"
+	"github.com/ssergomol/raft/persist"
+	"github.com/ssergomol/raft/trace"
+)
+
+// Cluster administration on the client listener:
+//
+//	GET  /v1/members
+//	POST /v1/members          {"name": "", "peer_addr": "", "client_addr": ""}
+//	POST /v1/leader/transfer  {"target": ""}
+//	GET  /v1/snapshot
+//
+// Members are the servers of the registry, adding one replicates a MEMBER
+// command so every node registers it, after which the leader replicates its
+// log to it. A leadership transfer waits for the target to have
+// the whole log and then has it start an election right away. Everything but
+// the member list needs the root role once authentication is enabled
+
+// TransferTimeout is how long a leadership transfer waits for the target to catch up
+const TransferTimeout = 2 * time.Second
+
+type member struct {
+	Name       string `json:"name"`
+	PeerAddr   string `json:"peer_addr"`
+	ClientAddr string `json:"client_addr"`
+	Leader     bool   `json:"leader,omitempty"`
+}
+
+func registerClusterRoutes(mux *http.ServeMux, s *Server) {
+	mux.HandleFunc("/v1/members", s.handleMembers)
+	mux.HandleFunc("/v1/leader/transfer", s.handleLeaderTransfer)
+	mux.HandleFunc("/v1/snapshot", s.handleSnapshot)
+}
+
+// authorizeRoot checks that the caller has the root role and answers the request if not
+func (s *Server) authorizeRoot(w http.ResponseWriter, r *http.Request) bool {
+	user, err := s.requestUser(r)
+	if err == nil {
+		err = s.db.AuthorizeRoot(user)
+	}
+	if err != nil {
+		writeError(w, authStatusCode(err), err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleMembers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if _, err := s.requestUser(r); err != nil {
+			writeError(w, authStatusCode(err), err.Error())
+			return
+		}
+		peerAddrs, _ := persist.ListAllServers()
+		clientAddrs, _ := persist.ListClientAddrs()
+		members := make([]member, 0, len(peerAddrs))
+		for name, peerAddr := range peerAddrs {
+			members = append(members, member{Name: name, PeerAddr: peerAddr, ClientAddr: clientAddrs[name], Leader: name == s.leaderNodeId})
+		}
+		writeJSON(w, http.StatusOK, members)
+	case http.MethodPost:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "error reading request body")
+			return
+		}
+		defer r.Body.Close()
+
+		var m member
+		if err := json.Unmarshal(body, &m); err != nil {
+			writeError(w, http.StatusBadRequest, "need a JSON body with a name, a peer and a client address")
+			return
+		}
+		command := "MEMBER " + m.Name + " " + m.PeerAddr + " " + m.ClientAddr
+		if strings.Contains(m.Name+m.PeerAddr+m.ClientAddr, " ") {
+			writeError(w, http.StatusBadRequest, "member names and addresses can't contain spaces")
+			return
+		}
+		result, ok := s.proposeV1(w, r, body, command)
+		if !ok {
+			return
+		}
+		if result.Status != database.StatusOK {
+			writeError(w, resultStatusCode(result.Status), result.Message)
+			return
+		}
+		s.log.Info("member added", "member", m.Name, "peer_addr", m.PeerAddr, "client_addr", m.ClientAddr)
+		writeJSON(w, http.StatusOK, m)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "only GET and POST are supported for members")
+	}
+}
+
+func (s *Server) handleLeaderTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "only POST is supported for leadership transfers")
+		return
+	}
+	if !s.authorizeRoot(w, r) {
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "error reading request body")
+		return
+	}
+	defer r.Body.Close()
+
+	var req struct {
+		Target string `json:"target"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Target == "" {
+		writeError(w, http.StatusBadRequest, "need a JSON body with a target")
+		return
+	}
+	if s.currentRole != "leader" {
+		s.forwardToLeader(w, r, body)
+		return
+	}
+	if err := s.transferLeadership(req.Target); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"message": "leadership transfer to " + req.Target + " started"})
+}
+
+// identifyCluster has the first leader of a new cluster generate a random ID
+// for it and replicate it, the members send it in their envelopes once it's
+// committed, see database.PerformCluster. Members of an existing cluster learn
+// the ID from the envelopes of the leader
+func (s *Server) identifyCluster() {
+	if persist.ClusterId() != "" || *initialClusterState != "new" {
+		return
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	result := s.proposeCommand("CLUSTER " + hex.EncodeToString(id))
+	s.logger().Info("cluster ID proposed", "cluster", hex.EncodeToString(id), "status", result.Status, "result", result.Message)
+}
+
+// transferLeadership brings target up to date and has it start an election,
+// which this node loses as soon as it sees the higher term
+func (s *Server) transferLeadership(target string) error {
+	if target == s.serverState.Name {
+		return fmt.Errorf("%s is the leader already", target)
+	}
+	addr := peerAddr(target)
+	if addr == "" {
+		return fmt.Errorf("%s is not a member", target)
+	}
+	deadline := time.Now().Add(TransferTimeout)
+	for s.peerdata.AckedLength(target) < len(s.Logs) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s doesn't have the whole log yet", target)
+		}
+		s.replicateLog(target, addr)
+		time.Sleep(100 * time.Millisecond)
+	}
+	s.logger().Info("transferring leadership", "target", target)
+	s.sendMessageToFollowerNode(model.NewTimeoutNow(s.serverState.Name, s.serverState.CurrentTerm).String(), target, addr, trace.SpanContext{})
+	return nil
+}
+
+func (s *Server) handleTimeoutNow(message string) {
+	timeoutNow, err := model.ParseTimeoutNow(message)
+	if err != nil || timeoutNow.CurrentTerm != s.serverState.CurrentTerm || timeoutNow.LeaderId != s.leaderNodeId {
+		return
+	}
+	s.logger().Info("leadership handed over", "leader", timeoutNow.LeaderId)
+	go s.startElection()
+}
+
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported for snapshots")
+		return
+	}
+	if !s.authorizeRoot(w, r) {
+		return
+	}
+	start := time.Now()
+	snapshot := s.db.Snapshot()
+	s.metrics.snapshotDone(start)
+	s.emit(observer.Event{Type: observer.SnapshotTaken, Index: snapshot.AppliedIndex})
+	s.logger().Info("snapshot taken", "applied_index", snapshot.AppliedIndex, "entries", len(snapshot.Entries), "duration", time.Since(start))
+	writeJSON(w, http.StatusOK, snapshot)
+}
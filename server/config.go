@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/ssergomol/raft/persist"
+)
+
+// A node is configured with flags, with a JSON config file or both, flags given
+// on the command line win over the file. The file holds an object whose keys
+// are flag names, the initial cluster may also be a list of members:
+//
+//	{
+//	    "server-name": "n1",
+//	    "data-dir": "/var/lib/raft/n1",
+//	    "initial-cluster": [
+//	        {"name": "n1", "peer_addr": "10.0.0.1:17001", "client_addr": "10.0.0.1:18001"},
+//	        {"name": "n2", "peer_addr": "10.0.0.2:17001", "client_addr": "10.0.0.2:18001"}
+//	    ]
+//	}
+//
+// The initial cluster is the membership a node bootstraps its registry with,
+// on the command line it's name=peer-addr/client-addr,... The node's own
+// addresses default to its entry. Members added later are replicated through
+// the log, so a node restarting with its data directory needs no initial
+// cluster. The first leader of a new cluster generates its ID, a new member is
+// started with -initial-cluster-state existing and learns the ID from the
+// leader, see identifyCluster
+
+// loadConfigFile sets the flags of flags found in a config file that weren't
+// given on the command line
+func loadConfigFile(flags *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("config file %s isn't a JSON object: %v", path, err)
+	}
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	for name, raw := range values {
+		if flags.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown setting %s in config file %s", name, path)
+		}
+		if given[name] {
+			continue
+		}
+		value, err := configValue(name, raw)
+		if err != nil {
+			return fmt.Errorf("setting %s in config file %s: %v", name, path, err)
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("setting %s in config file %s: %v", name, path, err)
+		}
+	}
+	return nil
+}
+
+// configValue returns a setting of the config file in its flag syntax
+func configValue(name string, raw json.RawMessage) (string, error) {
+	var members []member
+	if name == "initial-cluster" && json.Unmarshal(raw, &members) == nil {
+		specs := make([]string, 0, len(members))
+		for _, m := range members {
+			specs = append(specs, m.Name+"="+m.PeerAddr+"/"+m.ClientAddr)
+		}
+		return strings.Join(specs, ","), nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", err
+	}
+	switch value.(type) {
+	case string, float64, bool:
+		return fmt.Sprint(value), nil
+	}
+	return "", errors.New("must be a string, a number or a boolean")
+}
+
+// parseInitialCluster parses name=peer-addr/client-addr,... and checks that
+// no name or address is used twice
+func parseInitialCluster(spec string) ([]member, error) {
+	var members []member
+	names := make(map[string]bool)
+	addrs := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		splits := strings.SplitN(entry, "=", 2)
+		if len(splits) != 2 {
+			return nil, fmt.Errorf("initial cluster entry %s isn't name=peer-addr/client-addr", entry)
+		}
+		addrSplits := strings.Split(splits[1], "/")
+		if len(addrSplits) != 2 {
+			return nil, fmt.Errorf("initial cluster entry %s isn't name=peer-addr/client-addr", entry)
+		}
+		m := member{Name: splits[0], PeerAddr: addrSplits[0], ClientAddr: addrSplits[1]}
+		if m.Name == "" || strings.ContainsAny(m.Name, " #,|") {
+			return nil, fmt.Errorf("not a valid member name %q", m.Name)
+		}
+		if names[m.Name] {
+			return nil, fmt.Errorf("member %s appears twice in the initial cluster", m.Name)
+		}
+		names[m.Name] = true
+		for _, addr := range []string{m.PeerAddr, m.ClientAddr} {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return nil, fmt.Errorf("not a valid address %s of member %s", addr, m.Name)
+			}
+			if other, ok := addrs[addr]; ok {
+				return nil, fmt.Errorf("address %s is used by both %s and %s", addr, other, m.Name)
+			}
+			addrs[addr] = m.Name
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// ownAddresses fills in the addresses of the node that weren't given from its
+// entry of the initial cluster, and checks that the given ones match it
+func ownAddresses(members []member) error {
+	for _, m := range members {
+		if m.Name != *serverName {
+			continue
+		}
+		if *peerAddress == "" {
+			*peerAddress = m.PeerAddr
+		}
+		if *clientAddress == "" {
+			*clientAddress = m.ClientAddr
+		}
+		if *peerAddress != m.PeerAddr || *clientAddress != m.ClientAddr {
+			return fmt.Errorf("addresses of %s differ from its initial cluster entry", *serverName)
+		}
+		return nil
+	}
+	return fmt.Errorf("initial cluster must include %s", *serverName)
+}
+
+// bootstrapRegistry registers the initial cluster, or only the node itself
+// without one, in the registry of the data directory
+func bootstrapRegistry(members []member) error {
+	if len(members) == 0 {
+		return persist.AddServer(*serverName, *peerAddress, *clientAddress)
+	}
+	for _, m := range members {
+		if err := persist.AddServer(m.Name, m.PeerAddr, m.ClientAddr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
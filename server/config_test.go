@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseInitialCluster(t *testing.T) {
+	members, err := parseInitialCluster("n1=127.0.0.1:7001/127.0.0.1:8001,n2=[::1]:7002/[::1]:8002")
+	if err != nil {
+		t.Fatalf("parseInitialCluster: %v", err)
+	}
+	if len(members) != 2 || members[1] != (member{Name: "n2", PeerAddr: "[::1]:7002", ClientAddr: "[::1]:8002"}) {
+		t.Fatalf("members = %+v", members)
+	}
+
+	for _, spec := range []string{
+		"n1=127.0.0.1:7001/127.0.0.1:8001,n1=127.0.0.1:7002/127.0.0.1:8002",
+		"n1=127.0.0.1:7001/127.0.0.1:8001,n2=127.0.0.1:7001/127.0.0.1:8002",
+		"n1=127.0.0.1:7001/127.0.0.1:7001",
+		"n1=127.0.0.1:7001",
+		"n1=127.0.0.1/127.0.0.1:8001",
+		"n1=http://127.0.0.1:7001/127.0.0.1:8001",
+		"n1=127.0.0.1:7001/",
+		"127.0.0.1:7001/127.0.0.1:8001",
+		"=127.0.0.1:7001/127.0.0.1:8001",
+		"n 1=127.0.0.1:7001/127.0.0.1:8001",
+		"n1=127.0.0.1:7001/127.0.0.1:8001,",
+	} {
+		if members, err := parseInitialCluster(spec); err == nil {
+			t.Errorf("parseInitialCluster(%q) = %+v, want an error", spec, members)
+		}
+	}
+}
+
+// setAddresses sets the name and the addresses of the node until the test ends
+func setAddresses(t *testing.T, name string, peer string, client string) {
+	t.Helper()
+	oldName, oldPeer, oldClient := *serverName, *peerAddress, *clientAddress
+	t.Cleanup(func() { *serverName, *peerAddress, *clientAddress = oldName, oldPeer, oldClient })
+	*serverName, *peerAddress, *clientAddress = name, peer, client
+}
+
+func TestOwnAddresses(t *testing.T) {
+	members, err := parseInitialCluster("n1=127.0.0.1:7001/127.0.0.1:8001,n2=127.0.0.1:7002/127.0.0.1:8002")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setAddresses(t, "n2", "", "")
+	if err := ownAddresses(members); err != nil {
+		t.Fatalf("ownAddresses: %v", err)
+	}
+	if *peerAddress != "127.0.0.1:7002" || *clientAddress != "127.0.0.1:8002" {
+		t.Fatalf("addresses = %s, %s, want the ones of the entry of n2", *peerAddress, *clientAddress)
+	}
+
+	setAddresses(t, "n2", "127.0.0.1:7002", "127.0.0.1:9002")
+	if err := ownAddresses(members); err == nil {
+		t.Fatal("ownAddresses accepted a client address other than the one of the entry")
+	}
+
+	setAddresses(t, "n3", "127.0.0.1:7003", "127.0.0.1:8003")
+	if err := ownAddresses(members); err == nil || !strings.Contains(err.Error(), "n3") {
+		t.Fatalf("ownAddresses of a node missing from the initial cluster = %v", err)
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	flags := flag.NewFlagSet("raft", flag.ContinueOnError)
+	name := flags.String("server-name", "", "")
+	dir := flags.String("data-dir", "", "")
+	cluster := flags.String("initial-cluster", "", "")
+	retention := flags.Int("auto-compaction-retention", 0, "")
+	if err := flags.Parse([]string{"-server-name", "n2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeConfigFile(t, `{
+		"server-name": "n1",
+		"data-dir": "/var/lib/raft/n1",
+		"auto-compaction-retention": 1000,
+		"initial-cluster": [
+			{"name": "n1", "peer_addr": "127.0.0.1:7001", "client_addr": "127.0.0.1:8001"},
+			{"name": "n2", "peer_addr": "127.0.0.1:7002", "client_addr": "127.0.0.1:8002"}
+		]
+	}`)
+	if err := loadConfigFile(flags, path); err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+	if *name != "n2" {
+		t.Fatalf("server name = %s, want the one of the command line", *name)
+	}
+	if *dir != "/var/lib/raft/n1" || *retention != 1000 {
+		t.Fatalf("data dir %s, retention %d, want the ones of the file", *dir, *retention)
+	}
+	if *cluster != "n1=127.0.0.1:7001/127.0.0.1:8001,n2=127.0.0.1:7002/127.0.0.1:8002" {
+		t.Fatalf("initial cluster = %s", *cluster)
+	}
+
+	for _, content := range []string{
+		`{"unknown": 1}`,
+		`{"config": "other.json"}`,
+		`{"data-dir": ["a", "b"]}`,
+		`{"auto-compaction-retention": "many"}`,
+		`["server-name", "n1"]`,
+	} {
+		flags := flag.NewFlagSet("raft", flag.ContinueOnError)
+		flags.String("data-dir", "", "")
+		flags.String("config", "", "")
+		flags.Int("auto-compaction-retention", 0, "")
+		if err := loadConfigFile(flags, writeConfigFile(t, content)); err == nil {
+			t.Errorf("loadConfigFile accepted %s", content)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ssergomol/raft/observer"
+)
+
+// The node emits observer events when it learns of a new leader, steps down,
+// moves to a new term, loses or regains a peer and takes a snapshot. Clients
+// follow them on the client listener as newline-delimited JSON:
+//
+//	GET /v1/events                                 every event
+//	GET /v1/events?type=LeaderElected,SteppedDown  only events of these types
+//
+// Like /status the stream needs no authentication. A client that doesn't keep
+// up has its stream closed and can read /status to resynchronize
+
+// emit hands an event to the observers of the node, filling in what the node
+// knows about itself
+func (s *Server) emit(event observer.Event) {
+	event.NodeId = s.serverState.Name
+	event.Term = s.serverState.CurrentTerm
+	event.Role = s.currentRole
+	if event.LeaderId == "" {
+		event.LeaderId = s.leaderNodeId
+	}
+	s.observer.Emit(event)
+}
+
+// setTerm moves the node to term, telling the observers if it's a new one. The
+// leader of a new term isn't known until it's heard from
+func (s *Server) setTerm(term int) {
+	if s.serverState.CurrentTerm == term {
+		return
+	}
+	s.serverState.CurrentTerm = term
+	s.leaderNodeId = ""
+	s.emit(observer.Event{Type: observer.TermChanged})
+}
+
+// setLeader records the leader of the current term, logging and telling the
+// observers when it changes
+func (s *Server) setLeader(leaderId string) {
+	if s.leaderNodeId == leaderId {
+		return
+	}
+	s.logger().Info("leader changed", "leader", leaderId)
+	s.leaderNodeId = leaderId
+	s.emit(observer.Event{Type: observer.LeaderElected})
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported for events")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	var types []observer.EventType
+	if query := r.URL.Query().Get("type"); query != "" {
+		for _, t := range strings.Split(query, ",") {
+			types = append(types, observer.EventType(t))
+		}
+	}
+
+	subscription := s.observer.Subscribe(types...)
+	defer s.observer.Unsubscribe(subscription)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
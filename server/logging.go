@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ssergomol/raft/database"
+	"github.com/ssergomol/raft/observer"
+)
+
+// The server logs structured records with log/slog, every record names the
+// node and the ones about the raft state also carry its term and role. The
+// level is set with -log-level and can be changed at runtime, debug traces
+// every raft message and client command:
+//
+//	GET /v1/debug/log-level
+//	PUT /v1/debug/log-level  {"level": "debug"}
+//
+// Changing the level needs the root role once authentication is enabled. The
+// passwords and secrets of AUTH commands are never logged
+
+// logLevel is the level of the logger, shared by every record of the process
+var logLevel = new(slog.LevelVar)
+
+// newLogger creates the logger of a node from -log-level and -log-format and
+// makes it the default one
+func newLogger(nodeId string) (*slog.Logger, error) {
+	if err := logLevel.UnmarshalText([]byte(*logLevelName)); err != nil {
+		return nil, fmt.Errorf("log level must be debug, info, warn or error")
+	}
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	switch *logFormat {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return nil, fmt.Errorf("log format must be text or json")
+	}
+	logger := slog.New(handler).With("node", nodeId)
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+// redactMessage hides the secrets of the AUTH commands in the entries of a
+// LogRequest, other raft messages carry none
+func redactMessage(message string) string {
+	if !strings.HasPrefix(message, "LogRequest|") || !strings.Contains(message, "AUTH ") {
+		return message
+	}
+	splits := strings.Split(message, "|")
+	entries := strings.Split(splits[len(splits)-1], ",")
+	for i, entry := range entries {
+		command, term, _ := strings.Cut(entry, "#")
+		entries[i] = database.RedactCommand(command) + "#" + term
+	}
+	splits[len(splits)-1] = strings.Join(entries, ",")
+	return strings.Join(splits, "|")
+}
+
+// logger returns the logger of the node with its current term and role
+func (s *Server) logger() *slog.Logger {
+	return s.log.With("term", s.serverState.CurrentTerm, "role", s.currentRole)
+}
+
+// setRole moves the node to role, logging the transition and telling the
+// observers if the node stepped down
+func (s *Server) setRole(role string) {
+	if s.currentRole == role {
+		return
+	}
+	s.log.Info("role changed", "term", s.serverState.CurrentTerm, "from", s.currentRole, "to", role)
+	previous := s.currentRole
+	s.currentRole = role
+	if previous == "leader" {
+		s.failProposals(0, "leadership lost before the entry was committed")
+		s.emit(observer.Event{Type: observer.SteppedDown})
+	}
+}
+
+func (s *Server) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if !s.authorizeRoot(w, r) {
+			return
+		}
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "need a JSON body with a level")
+			return
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+			writeError(w, http.StatusBadRequest, "level must be debug, info, warn or error")
+			return
+		}
+		logLevel.Set(level)
+		s.log.Info("log level changed", "level", level)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "only GET and PUT are supported for the log level")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"level": strings.ToLower(logLevel.Level().String())})
+}
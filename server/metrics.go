@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ssergomol/raft/persist"
+)
+
+// GET /metrics on the client listener exposes the state of the node in the
+// Prometheus text format. Gauges are read from the server when scraped, the
+// per-peer indexes are only kept up to date by the leader:
+//
+//	raft_term, raft_role{role}, raft_commit_index, raft_applied_index, raft_log_entries
+//	raft_peer_match_index{peer}, raft_peer_next_index{peer}
+//	raft_elections_total, raft_peer_rpc_errors_total{peer,type}
+//	raft_proposal_duration_seconds, raft_snapshot_duration_seconds
+
+// latencyBuckets are the upper bounds in seconds of the latency histograms
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []int
+	sum    float64
+	count  int
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int, len(latencyBuckets))}
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, h.count, name, h.sum, name, h.count)
+}
+
+// labelEscaper escapes label values the way the text format needs them
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue returns value quoted as a label value
+func labelValue(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+// rpcError identifies the counter of failed raft messages of one type to one peer
+type rpcError struct {
+	peer        string
+	messageType string
+}
+
+// metrics holds the counters and histograms of a node
+type metrics struct {
+	mu        sync.Mutex
+	elections int
+	rpcErrors map[rpcError]int
+	proposals *histogram
+	snapshots *histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		rpcErrors: make(map[rpcError]int),
+		proposals: newHistogram(),
+		snapshots: newHistogram(),
+	}
+}
+
+func (m *metrics) electionStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.elections++
+}
+
+func (m *metrics) rpcFailed(peer string, messageType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rpcErrors[rpcError{peer: peer, messageType: messageType}]++
+}
+
+func (m *metrics) proposalDone(start time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.proposals.observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) snapshotDone(start time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots.observe(time.Since(start).Seconds())
+}
+
+func writeGauge(w io.Writer, name string, help string, value int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported for metrics", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeGauge(w, "raft_term", "Current term of the node.", s.serverState.CurrentTerm)
+	fmt.Fprint(w, "# HELP raft_role Role of the node, 1 for the current one.\n# TYPE raft_role gauge\n")
+	for _, role := range []string{"follower", "candidate", "leader"} {
+		value := 0
+		if role == s.currentRole {
+			value = 1
+		}
+		fmt.Fprintf(w, "raft_role{role=%s} %d\n", labelValue(role), value)
+	}
+	writeGauge(w, "raft_commit_index", "Number of committed log entries.", s.serverState.CommitLength)
+	writeGauge(w, "raft_applied_index", "Index of the last log entry applied to the state machine.", s.db.AppliedIndex())
+	writeGauge(w, "raft_log_entries", "Number of entries in the log.", len(s.Logs))
+
+	allServers, _ := persist.ListAllServers()
+	peers := make([]string, 0, len(allServers))
+	for name := range allServers {
+		if name != s.serverState.Name {
+			peers = append(peers, name)
+		}
+	}
+	sort.Strings(peers)
+	progress := s.peerdata.Progress()
+	fmt.Fprint(w, "# HELP raft_peer_match_index Log entries the leader knows a peer has.\n# TYPE raft_peer_match_index gauge\n")
+	for _, peer := range peers {
+		fmt.Fprintf(w, "raft_peer_match_index{peer=%s} %d\n", labelValue(peer), progress.AckedLength[peer])
+	}
+	fmt.Fprint(w, "# HELP raft_peer_next_index Log entries the leader has sent a peer.\n# TYPE raft_peer_next_index gauge\n")
+	for _, peer := range peers {
+		fmt.Fprintf(w, "raft_peer_next_index{peer=%s} %d\n", labelValue(peer), progress.SentLength[peer])
+	}
+
+	m := s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP raft_elections_total Elections started by the node.\n# TYPE raft_elections_total counter\nraft_elections_total %d\n", m.elections)
+	fmt.Fprint(w, "# HELP raft_peer_rpc_errors_total Raft messages that couldn't be delivered to a peer.\n# TYPE raft_peer_rpc_errors_total counter\n")
+	errs := make([]rpcError, 0, len(m.rpcErrors))
+	for e := range m.rpcErrors {
+		errs = append(errs, e)
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].peer != errs[j].peer {
+			return errs[i].peer < errs[j].peer
+		}
+		return errs[i].messageType < errs[j].messageType
+	})
+	for _, e := range errs {
+		fmt.Fprintf(w, "raft_peer_rpc_errors_total{peer=%s,type=%s} %d\n", labelValue(e.peer), labelValue(e.messageType), m.rpcErrors[e])
+	}
+	m.proposals.write(w, "raft_proposal_duration_seconds", "Time from proposing a command to having it applied.")
+	m.snapshots.write(w, "raft_snapshot_duration_seconds", "Time taken to take a snapshot of the state machine.")
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ssergomol/raft/persist"
+)
+
+func TestMetricsEscapeLabelValues(t *testing.T) {
+	s, _ := newTestServer(t, "n1")
+	if err := persist.AddServer(`n"2`, "127.0.0.1:7002", "127.0.0.1:8002"); err != nil {
+		t.Fatal(err)
+	}
+	s.metrics.rpcFailed(`n"2`, "Log\\Request\nx")
+
+	w := httptest.NewRecorder()
+	s.handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		`raft_peer_match_index{peer="n\"2"} 0`,
+		`raft_peer_next_index{peer="n\"2"} 0`,
+		`raft_peer_rpc_errors_total{peer="n\"2",type="Log\\Request\nx"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics have no line %s:\n%s", line, body)
+		}
+	}
+}
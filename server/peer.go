@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/ssergomol/raft/model"
+	"github.com/ssergomol/raft/persist"
+	"github.com/ssergomol/raft/trace"
+)
+
+// Raft messages are exchanged on the peer listener only, every message type has
+// its own route so peer traffic can't be mistaken for client commands:
+//
+//	POST /raft/log-request
+//	POST /raft/log-response
+//	POST /raft/vote-request
+//	POST /raft/vote-response
+//	POST /raft/timeout-now
+//
+// Every message travels in a model.Envelope naming the cluster, the sender and
+// the recipient, messages from another cluster, from unknown nodes or meant
+// for another node are rejected. A node joining an existing cluster has no
+// cluster ID yet and takes the one of the first LogRequest it's sent. A traced
+// LogRequest carries the context of the leader's replication span, so the
+// follower's append joins the trace of the proposal
+var peerRoutes = map[string]string{
+	"LogRequest":   "/raft/log-request",
+	"LogResponse":  "/raft/log-response",
+	"VoteRequest":  "/raft/vote-request",
+	"VoteResponse": "/raft/vote-response",
+	"TimeoutNow":   "/raft/timeout-now",
+}
+
+func registerPeerRoutes(mux *http.ServeMux, s *Server) {
+	for messageType, route := range peerRoutes {
+		mux.HandleFunc(route, s.handlePeerMessage(messageType))
+	}
+}
+
+// peerURL returns the URL a raft message is posted to on the peer at addr
+func (s *Server) peerURL(addr string, message string) string {
+	messageType := strings.SplitN(message, "|", 2)[0]
+	return s.tls.peerScheme() + "://" + addr + peerRoutes[messageType]
+}
+
+// peerAddr returns the peer address of a registered node, "" if it's unknown
+func peerAddr(nodeId string) string {
+	allServers, _ := persist.ListAllServers()
+	return allServers[nodeId]
+}
+
+// handlePeerMessage serves the route of one raft message type, the reply, if
+// any, is written as the response body
+func (s *Server) handlePeerMessage(messageType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST is supported for raft messages", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		envelope, status, err := s.openEnvelope(string(body))
+		if err != nil {
+			s.log.Warn("rejected raft message", "type", messageType, "remote_addr", r.RemoteAddr, "err", err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+		message := envelope.Message
+		s.logger().Debug("received raft message", "peer", envelope.SenderId, "message", redactMessage(message))
+		if !strings.HasPrefix(message, messageType+"|") {
+			http.Error(w, "Expected a "+messageType+" message", http.StatusBadRequest)
+			return
+		}
+		if err := s.tls.verifyPeer(r, envelope.SenderId); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		var response string
+		switch messageType {
+		case "LogRequest":
+			var span *trace.Span
+			if parent := trace.ParseTraceparent(envelope.Traceparent); parent.IsValid() {
+				span = s.tracer.Start(parent, "raft.follower.append")
+			}
+			span.SetAttribute("node", s.serverState.Name)
+			span.SetAttribute("leader", envelope.SenderId)
+			response = s.handleLogRequest(message)
+			span.End()
+		case "LogResponse":
+			response = s.handleLogResponse(message)
+		case "VoteRequest":
+			response = s.handleVoteRequest(message)
+		case "VoteResponse":
+			s.handleVoteResponse(message)
+		case "TimeoutNow":
+			s.handleTimeoutNow(message)
+		}
+		if response != "" && response != "replication successful" {
+			w.Write([]byte(s.seal(response, envelope.SenderId, trace.SpanContext{}) + "\n"))
+		}
+	}
+}
+
+// seal puts a message for recipientId in an envelope, together with the
+// context of the span it's sent in if it's traced
+func (s *Server) seal(message string, recipientId string, sc trace.SpanContext) string {
+	envelope := model.NewEnvelope(persist.ClusterId(), s.serverState.Name, recipientId, message)
+	envelope.Traceparent = sc.Traceparent()
+	return envelope.String()
+}
+
+// openEnvelope checks that a message was sent to this node by a member of its
+// cluster, on failure it also returns the status code to reject the message with
+func (s *Server) openEnvelope(data string) (*model.Envelope, int, error) {
+	envelope, err := model.ParseEnvelope(data)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if envelope.RecipientId != s.serverState.Name {
+		return nil, http.StatusMisdirectedRequest, fmt.Errorf("message for %s reached %s", envelope.RecipientId, s.serverState.Name)
+	}
+	if peerAddr(envelope.SenderId) == "" {
+		return nil, http.StatusForbidden, fmt.Errorf("%s is not a member", envelope.SenderId)
+	}
+	if messageSender(envelope.Message) != envelope.SenderId {
+		return nil, http.StatusBadRequest, errors.New("message wasn't written by the sender of its envelope")
+	}
+	clusterId := persist.ClusterId()
+	if clusterId == "" && envelope.ClusterId != "" && strings.HasPrefix(envelope.Message, "LogRequest|") {
+		if err := persist.SetClusterId(envelope.ClusterId); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		clusterId = envelope.ClusterId
+		s.log.Info("joined cluster", "cluster", clusterId, "through", envelope.SenderId)
+	}
+	if envelope.ClusterId != clusterId {
+		return nil, http.StatusForbidden, fmt.Errorf("message from cluster %s reached cluster %s", envelope.ClusterId, clusterId)
+	}
+	return envelope, http.StatusOK, nil
+}
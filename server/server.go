@@ -2,37 +2,85 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ssergomol/raft/database"
 
 	"github.com/ssergomol/raft/model"
 
-	"github.com/ssergomol/raft/logger"
+	"github.com/ssergomol/raft/observer"
+	"github.com/ssergomol/raft/persist"
+	"github.com/ssergomol/raft/trace"
 )
 
 var (
-	serverName = flag.String("server-name", "", "name for the server")
-	port       = flag.String("port", "", "port for running the server")
+	serverName    = flag.String("server-name", "", "name for the server")
+	peerAddress   = flag.String("peer-addr", "", "host:port the raft peer listener binds to")
+	clientAddress = flag.String("client-addr", "", "host:port the client API listener binds to")
+
+	configFile          = flag.String("config", "", "JSON config file whose keys are flag names, flags on the command line take precedence")
+	initialCluster      = flag.String("initial-cluster", "", "bootstrap membership as name=peer-addr/client-addr,...")
+	initialClusterState = flag.String("initial-cluster-state", "new", "new to bootstrap a cluster, existing to join one through member add")
+	dataDir             = flag.String("data-dir", "", "directory of the node's persisted files, <server-name>.data by default")
+
+	storageEngine = flag.String("storage-engine", "memory", "storage engine of the key value store, memory or disk")
+	storagePath   = flag.String("storage-path", "", "data file of the disk storage engine, snap/kv.db in the data directory by default")
+
+	followerMode = flag.String("follower-mode", "proxy", "how followers answer writes, proxy them to the leader or redirect the client to it")
+	proxyTimeout = flag.Duration("proxy-timeout", 5*time.Second, "timeout of writes a follower proxies to the leader")
+
+	legacyAPI = flag.Bool("legacy-api", true, "serve the text protocol on / and the pre-v1 endpoints next to the /v1 API")
+
+	logLevelName = flag.String("log-level", "info", "level of the logged records, debug, info, warn or error")
+	logFormat    = flag.String("log-format", "text", "format of the logged records, text or json")
+
+	traceExporter = flag.String("trace-exporter", "none", "where the spans of traced writes go, none, log or memory")
+
+	compactionRetention = flag.Int("auto-compaction-retention", 0, "number of log entries whose key revisions are kept, 0 disables automatic compaction")
+
+	peerCertFile      = flag.String("peer-cert-file", "", "certificate of the node for peer RPCs, enables mutual TLS between peers")
+	peerKeyFile       = flag.String("peer-key-file", "", "key of the peer certificate")
+	peerTrustedCAFile = flag.String("peer-trusted-ca-file", "", "CA certificates peer certificates are verified with")
+
+	clientCertFile      = flag.String("client-cert-file", "", "certificate of the client listener, enables TLS for clients")
+	clientKeyFile       = flag.String("client-key-file", "", "key of the client certificate")
+	clientTrustedCAFile = flag.String("client-trusted-ca-file", "", "CA certificates client certificates are verified with, enables client certificate authentication")
+	leaderTrustedCAFile = flag.String("leader-trusted-ca-file", "", "CA certificates the leader's client certificate is verified with when writes are forwarded to it, defaults to the client and then the peer trusted CA")
 )
 
+// Headers a client sets to have its writes applied at most once, see database.WithSession
 const (
-	BroadcastPeriod    = 3000
-	ElectionMinTimeout = 3001
-	ElectionMaxTimeout = 10000
+	ClientIdHeader  = "X-Client-Id"
+	ClientSeqHeader = "X-Client-Seq"
+)
+
+// LeaderHeader carries the client address of the leader known to the node on
+// every client response, so clients can send their requests to it directly
+const LeaderHeader = "X-Raft-Leader"
+
+const (
+	ExpiryCheckPeriod     = 500
+	CompactionCheckPeriod = 10000
+	BroadcastPeriod       = 3000
+	ElectionMinTimeout    = 3001
+	ElectionMaxTimeout    = 10000
 )
 
 type Server struct {
-	port           string
 	db             *database.Database
 	serverState    *model.ServerState
 	Logs           []string
@@ -40,38 +88,66 @@ type Server struct {
 	leaderNodeId   string
 	peerdata       *model.PeerData
 	electionModule *model.ElectionModule
+	commitMu       sync.Mutex
+	pendingMu      sync.Mutex
+	pending        map[int]proposal
+	tls            *transportSecurity
+	metrics        *metrics
+	log            *slog.Logger
+	leaderCommit   int
+	caughtUp       bool
+	tracer         *trace.Tracer
+	spans          *trace.InMemoryExporter
+	traces         map[int]trace.SpanContext
+	observer       *observer.Observer
 }
 
-func (s *Server) sendMessageToFollowerNode(message string, port int) {
-	addr := "http://127.0.0.1:" + strconv.Itoa(port)
-	reqBody := []byte(message)
-	resp, err := http.Post(addr, "text/plain", bytes.NewBuffer(reqBody))
+func (s *Server) sendMessageToFollowerNode(message string, nodeId string, addr string, sc trace.SpanContext) {
+	s.logger().Debug("sending raft message", "peer", nodeId, "message", redactMessage(message))
+	reqBody := []byte(s.seal(message, nodeId, sc))
+	resp, err := s.tls.peerClient(addr).Post(s.peerURL(addr, message), "text/plain", bytes.NewBuffer(reqBody))
 
 	if err != nil || resp.StatusCode != http.StatusOK {
-		s.peerdata.SuspectedNodes[port] = true
+		if s.peerdata.Suspect(addr) {
+			s.emit(observer.Event{Type: observer.PeerUnreachable, Peer: nodeId})
+		}
+		s.metrics.rpcFailed(nodeId, strings.SplitN(message, "|", 2)[0])
 		return
 	}
-	_, ok := s.peerdata.SuspectedNodes[port]
-	if ok {
-		delete(s.peerdata.SuspectedNodes, port)
+	if s.peerdata.Recover(addr) {
+		s.emit(observer.Event{Type: observer.PeerRecovered, Peer: nodeId})
 	}
 
 	go s.handleResponse(resp, addr)
 }
 
-func (s *Server) replicateLog(followerName string, followerPort int) {
+func (s *Server) replicateLog(followerName string, followerAddr string) {
 	if followerName == s.serverState.Name {
 		go s.commitLogEntries()
 		return
 	}
 	var prefixTerm = 0
-	prefixLength := s.peerdata.SentLength[followerName]
+	prefixLength := s.peerdata.SentLength(followerName)
 	if prefixLength > 0 {
 		logSplit := strings.Split(s.Logs[prefixLength-1], "#")
 		prefixTerm, _ = strconv.Atoi(logSplit[1])
 	}
-	logRequest := model.NewLogRequest(s.serverState.Name, s.serverState.CurrentTerm, prefixLength, prefixTerm, s.serverState.CommitLength, s.Logs[s.peerdata.SentLength[followerName]:])
-	s.sendMessageToFollowerNode(logRequest.String(), followerPort)
+	logRequest := model.NewLogRequest(s.serverState.Name, s.serverState.CurrentTerm, prefixLength, prefixTerm, s.serverState.CommitLength, s.Logs[prefixLength:])
+
+	// the request joins the trace of the newest proposal it carries, heartbeats
+	// and entries nobody waits for aren't traced
+	var parent trace.SpanContext
+	for i := len(s.Logs) - 1; i >= prefixLength && !parent.IsValid(); i-- {
+		parent = s.proposalTrace(i)
+	}
+	var span *trace.Span
+	if parent.IsValid() {
+		span = s.tracer.Start(parent, "raft.replicate")
+	}
+	span.SetAttribute("peer", followerName)
+	span.SetAttribute("entries", strconv.Itoa(len(logRequest.Suffix)))
+	defer span.End()
+	s.sendMessageToFollowerNode(logRequest.String(), followerName, followerAddr, span.Context())
 }
 
 func (s *Server) addLogs(log string) []string {
@@ -88,7 +164,9 @@ func (s *Server) appendEntries(prefixLength int, commitLength int, suffix []stri
 			index = len(s.Logs) - 1
 		}
 		if parseLogTerm(s.Logs[index]) != parseLogTerm(suffix[index-prefixLength]) {
+			s.logger().Warn("log conflict, truncating uncommitted entries", "index", index+1, "kept", prefixLength, "dropped", len(s.Logs)-prefixLength)
 			s.Logs = s.Logs[:prefixLength]
+			s.failProposals(prefixLength, "entry was overwritten by the leader")
 
 		}
 	}
@@ -98,55 +176,74 @@ func (s *Server) appendEntries(prefixLength int, commitLength int, suffix []stri
 			s.addLogs(suffix[i])
 			err := s.db.LogCommand(suffix[i], s.serverState.Name)
 			if err != nil {
-				fmt.Println(err)
+				s.logger().Error("failed to append to the log", "err", err)
 			}
 		}
 	}
 
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
 	if commitLength > s.serverState.CommitLength {
 		for i := s.serverState.CommitLength; i < commitLength; i++ {
-			s.db.PerformDbOperations(strings.Split(s.Logs[i], "#")[0])
+			result := s.db.PerformDbOperations(i+1, strings.Split(s.Logs[i], "#")[0])
+			s.serverState.CommitLength = i + 1
+			s.notifyProposer(i, parseLogTerm(s.Logs[i]), result)
 		}
-		s.serverState.CommitLength = commitLength
-		s.serverState.LogServerPersistedState()
+		s.persistState()
 	}
 }
 
+// persistState persists the hard state, a message carrying a new term or vote
+// must not leave the node unless it succeeds
+func (s *Server) persistState() bool {
+	if err := s.serverState.Persist(); err != nil {
+		s.logger().Error("failed to persist hard state", "err", err)
+		return false
+	}
+	return true
+}
+
 func (s *Server) handleLogResponse(message string) string {
 	lr, _ := model.ParseLogResponse(message)
 	if lr.CurrentTerm > s.serverState.CurrentTerm {
-		s.serverState.CurrentTerm = lr.CurrentTerm
-		s.currentRole = "follower"
+		s.logger().Info("newer term seen", "peer", lr.NodeId, "new_term", lr.CurrentTerm)
+		s.setTerm(lr.CurrentTerm)
+		s.setRole("follower")
 		s.serverState.VotedFor = ""
+		s.persistState()
 		go s.electionTimer()
 	}
 	if lr.CurrentTerm == s.serverState.CurrentTerm && s.currentRole == "leader" {
-		if lr.ReplicationSuccessful && lr.AckLength >= s.peerdata.AckedLength[lr.NodeId] {
-			s.peerdata.SentLength[lr.NodeId] = lr.AckLength
-			s.peerdata.AckedLength[lr.NodeId] = lr.AckLength
+		if lr.ReplicationSuccessful && s.peerdata.Ack(lr.NodeId, lr.AckLength) {
 			s.commitLogEntries()
 		} else {
-			s.peerdata.SentLength[lr.NodeId] = s.peerdata.SentLength[lr.NodeId] - 1
-			s.replicateLog(lr.NodeId, lr.Port)
+			s.peerdata.DecrementSentLength(lr.NodeId)
+			s.replicateLog(lr.NodeId, peerAddr(lr.NodeId))
 		}
 	}
 	return "replication successful"
 }
 
 func (s *Server) handleLogRequest(message string) string {
-	fmt.Println("Got log request")
 	s.electionModule.ResetElectionTimer <- struct{}{}
 	logRequest, _ := model.ParseLogRequest(message)
 	if logRequest.CurrentTerm > s.serverState.CurrentTerm {
-		s.serverState.CurrentTerm = logRequest.CurrentTerm
+		s.logger().Info("newer term seen", "peer", logRequest.LeaderId, "new_term", logRequest.CurrentTerm)
+		s.setTerm(logRequest.CurrentTerm)
 		s.serverState.VotedFor = ""
+		if !s.persistState() {
+			return ""
+		}
 	}
 	if logRequest.CurrentTerm == s.serverState.CurrentTerm {
 		if s.currentRole == "leader" {
 			go s.electionTimer()
 		}
-		s.currentRole = "follower"
-		s.leaderNodeId = logRequest.LeaderId
+		s.setRole("follower")
+		s.setLeader(logRequest.LeaderId)
+		if s.leaderCommit < 0 {
+			s.leaderCommit = logRequest.CommitLength
+		}
 	}
 	var logOk bool = false
 	if len(s.Logs) >= logRequest.PrefixLength &&
@@ -154,43 +251,175 @@ func (s *Server) handleLogRequest(message string) string {
 			parseLogTerm(s.Logs[logRequest.PrefixLength-1]) == logRequest.PrefixTerm) {
 		logOk = true
 	}
-	port, _ := strconv.Atoi(s.port)
 	if s.serverState.CurrentTerm == logRequest.CurrentTerm && logOk {
 		s.appendEntries(logRequest.PrefixLength, logRequest.CommitLength, logRequest.Suffix)
 		ack := logRequest.PrefixLength + len(logRequest.Suffix)
-		return model.NewLogResponse(s.serverState.Name, port, s.serverState.CurrentTerm, ack, true).String()
+		return model.NewLogResponse(s.serverState.Name, s.serverState.CurrentTerm, ack, true).String()
 	} else {
-		return model.NewLogResponse(s.serverState.Name, port, s.serverState.CurrentTerm, 0, false).String()
+		return model.NewLogResponse(s.serverState.Name, s.serverState.CurrentTerm, 0, false).String()
 	}
 }
 
+// commitLogEntries applies the entries acknowledged by a majority. It runs
+// from the goroutine of every log response, commitMu has them apply each
+// entry once and in order, so the commit length never passes an entry that
+// isn't applied and the proposer gets the result of the apply
 func (s *Server) commitLogEntries() {
-	allNodes, _ := logger.ListAllServers()
-	aliveNodes := len(allNodes) - len(s.peerdata.SuspectedNodes)
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+	allNodes, _ := persist.ListAllServers()
+	aliveNodes := len(allNodes) - s.peerdata.SuspectedCount()
 	for i := s.serverState.CommitLength; i < len(s.Logs); i++ {
 		var acks = 0
 		for node := range allNodes {
-			if s.peerdata.AckedLength[node] > s.serverState.CommitLength {
+			if s.peerdata.AckedLength(node) > s.serverState.CommitLength {
 				acks = acks + 1
 			}
 		}
 		if acks >= (aliveNodes+1)/2 || aliveNodes == 1 {
+			commitSpan := s.tracer.Start(s.proposalTrace(i), "raft.commit")
+			commitSpan.SetAttribute("index", strconv.Itoa(i+1))
+			commitSpan.SetAttribute("acks", strconv.Itoa(acks))
 			log := s.Logs[i]
 			command := strings.Split(log, "#")[0]
-			s.db.PerformDbOperations(command)
+			applySpan := s.tracer.Start(commitSpan.Context(), "raft.apply")
+			result := s.db.PerformDbOperations(i+1, command)
+			applySpan.End()
 			s.serverState.CommitLength = s.serverState.CommitLength + 1
-			s.serverState.LogServerPersistedState()
+			s.persistState()
+			commitSpan.End()
+			s.notifyProposer(i, parseLogTerm(log), result)
 		} else {
 			break
 		}
 	}
 }
 
+// proposal is a client request waiting for the log entry it appended in term
+type proposal struct {
+	term   int
+	result chan database.Result
+}
+
+// notifyProposer hands the result of applying the log entry at index, written
+// in term, to the client request waiting for it, if any. A request waiting for
+// an entry of another term lost it to another leader and fails instead
+func (s *Server) notifyProposer(index int, term int, result database.Result) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if p, ok := s.pending[index]; ok {
+		if p.term != term {
+			result = database.Result{Status: database.StatusUnavailable, Message: "entry was overwritten by the leader"}
+		}
+		p.result <- result
+		delete(s.pending, index)
+	}
+	delete(s.traces, index)
+}
+
+// failProposals fails the client requests waiting for the log entries from
+// index on, the outcome of their commands is unknown
+func (s *Server) failProposals(index int, message string) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	for i, p := range s.pending {
+		if i >= index {
+			p.result <- database.Result{Status: database.StatusUnavailable, Message: message}
+			delete(s.pending, i)
+			delete(s.traces, i)
+		}
+	}
+}
+
+// proposalTrace returns the context of the span proposing the log entry at
+// index, an invalid one if the entry isn't traced
+func (s *Server) proposalTrace(index int) trace.SpanContext {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	return s.traces[index]
+}
+
+// proposeCommand appends a command to the leader's log, replicates it and
+// waits until it's committed, returning the result of applying it
+func (s *Server) proposeCommand(message string) database.Result {
+	return s.propose(context.Background(), trace.SpanContext{}, message)
+}
+
+// proposeRequest is proposeCommand for a client request, it gives up when the
+// client does and joins the client's trace
+func (s *Server) proposeRequest(r *http.Request, message string) database.Result {
+	return s.propose(r.Context(), requestTrace(r), message)
+}
+
+// propose is proposeCommand until ctx is done, within the trace of parent. The
+// request fails if the node loses the leadership or the entry before it's
+// committed
+func (s *Server) propose(ctx context.Context, parent trace.SpanContext, message string) database.Result {
+	defer s.metrics.proposalDone(time.Now())
+	span := s.tracer.Start(parent, "raft.propose")
+	defer span.End()
+	term := s.serverState.CurrentTerm
+	logMessage := database.StampCommand(message, time.Now()) + "#" + strconv.Itoa(term)
+
+	// the entry is written to disk before another one is appended, so a failed
+	// write can be taken back
+	s.pendingMu.Lock()
+	if s.currentRole != "leader" {
+		s.pendingMu.Unlock()
+		return database.Result{Status: database.StatusUnavailable, Message: "not leader"}
+	}
+	s.peerdata.SetAckedLength(s.serverState.Name, len(s.Logs))
+	s.Logs = append(s.Logs, logMessage)
+	currLogIdx := len(s.Logs) - 1
+	result := make(chan database.Result, 1)
+	s.pending[currLogIdx] = proposal{term: term, result: result}
+	if span != nil {
+		s.traces[currLogIdx] = span.Context()
+	}
+	span.SetAttribute("index", strconv.Itoa(currLogIdx+1))
+
+	appendSpan := s.tracer.Start(span.Context(), "raft.append")
+	err := s.db.LogCommand(logMessage, s.serverState.Name)
+	appendSpan.End()
+	if err != nil {
+		s.Logs = s.Logs[:currLogIdx]
+		delete(s.pending, currLogIdx)
+		delete(s.traces, currLogIdx)
+		s.pendingMu.Unlock()
+		s.logger().Error("failed to append to the log", "err", err)
+		return database.Result{Status: database.StatusError, Message: "error while logging command"}
+	}
+	s.pendingMu.Unlock()
+
+	allServers, _ := persist.ListAllServers()
+	for sname, saddr := range allServers {
+		s.replicateLog(sname, saddr)
+	}
+
+	s.logger().Debug("waiting for consensus", "index", currLogIdx+1)
+	select {
+	case res := <-result:
+		return res
+	case <-ctx.Done():
+		s.pendingMu.Lock()
+		defer s.pendingMu.Unlock()
+		select {
+		case res := <-result:
+			return res
+		default:
+		}
+		delete(s.pending, currLogIdx)
+		delete(s.traces, currLogIdx)
+		return database.Result{Status: database.StatusUnavailable, Message: "request cancelled before the entry was committed"}
+	}
+}
+
 func (s *Server) handleVoteRequest(message string) string {
 	voteRequest, _ := model.ParseVoteRequest(message)
 	if voteRequest.CandidateTerm > s.serverState.CurrentTerm {
-		s.serverState.CurrentTerm = voteRequest.CandidateTerm
-		s.currentRole = "follower"
+		s.logger().Info("newer term seen", "peer", voteRequest.CandidateId, "new_term", voteRequest.CandidateTerm)
+		s.setTerm(voteRequest.CandidateTerm)
+		s.setRole("follower")
 		s.serverState.VotedFor = ""
 		s.electionModule.ResetElectionTimer <- struct{}{}
 	}
@@ -204,17 +433,16 @@ func (s *Server) handleVoteRequest(message string) string {
 		logOk = true
 	}
 
-	if voteRequest.CandidateTerm == s.serverState.CurrentTerm && logOk && (s.serverState.VotedFor == "" || s.serverState.VotedFor == voteRequest.CandidateId) {
+	voteInFavor := voteRequest.CandidateTerm == s.serverState.CurrentTerm && logOk && (s.serverState.VotedFor == "" || s.serverState.VotedFor == voteRequest.CandidateId)
+	if voteInFavor {
 		s.serverState.VotedFor = voteRequest.CandidateId
-		s.serverState.LogServerPersistedState()
-		return model.NewVoteResponse(
-			s.serverState.Name,
-			s.serverState.CurrentTerm,
-			true,
-		).String()
-	} else {
-		return model.NewVoteResponse(s.serverState.Name, s.serverState.CurrentTerm, false).String()
 	}
+	s.logger().Info("vote requested", "candidate", voteRequest.CandidateId, "granted", voteInFavor)
+	// the vote and the term must survive a crash before they're answered
+	if !s.persistState() {
+		return ""
+	}
+	return model.NewVoteResponse(s.serverState.Name, s.serverState.CurrentTerm, voteInFavor).String()
 }
 
 func (s *Server) handleVoteResponse(message string) {
@@ -223,9 +451,11 @@ func (s *Server) handleVoteResponse(message string) {
 		if s.currentRole != "leader" {
 			s.electionModule.ResetElectionTimer <- struct{}{}
 		}
-		s.serverState.CurrentTerm = voteResponse.CurrentTerm
-		s.currentRole = "follower"
+		s.logger().Info("newer term seen", "peer", voteResponse.NodeId, "new_term", voteResponse.CurrentTerm)
+		s.setTerm(voteResponse.CurrentTerm)
+		s.setRole("follower")
 		s.serverState.VotedFor = ""
+		s.persistState()
 	}
 	if s.currentRole == "candidate" && voteResponse.CurrentTerm == s.serverState.CurrentTerm && voteResponse.VoteInFavor {
 		s.peerdata.VotesReceived[voteResponse.NodeId] = true
@@ -243,23 +473,33 @@ func (s *Server) checkForElectionResult() {
 			totalVotes += 1
 		}
 	}
-	allNodes, _ := logger.ListAllServers()
-	aliveNodes := len(allNodes) - len(s.peerdata.SuspectedNodes)
+	allNodes, _ := persist.ListAllServers()
+	aliveNodes := len(allNodes) - s.peerdata.SuspectedCount()
 
 	if (totalVotes >= (aliveNodes+1)/2) || aliveNodes == 1 {
-		fmt.Println("I won the election. New leader: ", s.serverState.Name, " Votes received: ", totalVotes)
-		s.currentRole = "leader"
-		s.leaderNodeId = s.serverState.Name
+		s.logger().Info("election won", "votes", totalVotes, "alive", aliveNodes)
+		s.setRole("leader")
+		s.setLeader(s.serverState.Name)
 		s.peerdata.VotesReceived = make(map[string]bool)
 		s.electionModule.ElectionTimeout.Stop()
+		go s.expireKeys()
+		go s.compactRevisions()
+		go s.identifyCluster()
 		s.syncUp()
 	}
 }
 
 func (s *Server) startElection() {
-	s.serverState.CurrentTerm = s.serverState.CurrentTerm + 1
-	s.currentRole = "candidate"
+	s.setTerm(s.serverState.CurrentTerm + 1)
 	s.serverState.VotedFor = s.serverState.Name
+	// a restart must not vote again in the term the node has voted for itself in
+	if !s.persistState() {
+		s.electionModule.ResetElectionTimer <- struct{}{}
+		return
+	}
+	s.setRole("candidate")
+	s.logger().Info("election started")
+	s.metrics.electionStarted()
 	s.peerdata.VotesReceived = map[string]bool{}
 	s.peerdata.VotesReceived[s.serverState.Name] = true
 	var lastTerm = 0
@@ -268,10 +508,10 @@ func (s *Server) startElection() {
 	}
 
 	voteRequest := model.NewVoteRequest(s.serverState.Name, s.serverState.CurrentTerm, len(s.Logs), lastTerm)
-	allNodes, _ := logger.ListAllServers()
-	for node, port := range allNodes {
+	allNodes, _ := persist.ListAllServers()
+	for node, addr := range allNodes {
 		if node != s.serverState.Name {
-			s.sendMessageToFollowerNode(voteRequest.String(), port)
+			s.sendMessageToFollowerNode(voteRequest.String(), node, addr, trace.SpanContext{})
 		}
 	}
 	s.checkForElectionResult()
@@ -281,15 +521,14 @@ func (s *Server) electionTimer() {
 	for {
 		select {
 		case <-s.electionModule.ElectionTimeout.C:
-			fmt.Println("Timed out")
+			s.logger().Debug("election timeout")
 			if s.currentRole == "follower" {
 				go s.startElection()
 			} else {
-				s.currentRole = "follower"
+				s.setRole("follower")
 				s.electionModule.ResetElectionTimer <- struct{}{}
 			}
 		case <-s.electionModule.ResetElectionTimer:
-			fmt.Println("Resetting election timer")
 			s.electionModule.ElectionTimeout.Reset(time.Duration(s.electionModule.ElectionTimeoutInterval) * time.Millisecond)
 		}
 	}
@@ -297,23 +536,83 @@ func (s *Server) electionTimer() {
 
 func (s *Server) syncUp() {
 	ticker := time.NewTicker(BroadcastPeriod * time.Millisecond)
-	for t := range ticker.C {
-		fmt.Println("sending heartbeat at: ", t)
-		allServers, _ := logger.ListAllServers()
-		for sname, sport := range allServers {
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.currentRole != "leader" {
+			return
+		}
+		s.logger().Debug("sending heartbeats")
+		allServers, _ := persist.ListAllServers()
+		for sname, saddr := range allServers {
 			if sname != s.serverState.Name {
-				s.replicateLog(sname, sport)
+				s.replicateLog(sname, saddr)
 			}
 		}
 	}
 }
 
+// expireKeys replicates EXPIRE commands for keys whose time-to-live is over
+// for as long as the node stays the leader
+func (s *Server) expireKeys() {
+	ticker := time.NewTicker(ExpiryCheckPeriod * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.currentRole != "leader" {
+			return
+		}
+		for _, command := range s.db.ExpiredKeys(time.Now()) {
+			s.logger().Debug("expiring key", "command", command)
+			s.proposeCommand(command)
+		}
+	}
+}
+
+// compactRevisions periodically replicates a COMPACT command keeping the key
+// revisions of the last auto-compaction-retention log entries
+func (s *Server) compactRevisions() {
+	if *compactionRetention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(CompactionCheckPeriod * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.currentRole != "leader" {
+			return
+		}
+		revision := s.serverState.CommitLength - *compactionRetention
+		if revision > s.db.CompactRevision() {
+			s.logger().Info("compacting revisions", "revision", revision)
+			s.proposeCommand("COMPACT " + strconv.Itoa(revision))
+		}
+	}
+}
+
 func main() {
-	parseFlags()
+	members := parseFlags()
+	logger, err := newLogger(*serverName)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	tracer, spans, err := newTracer(logger)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
-	db, err := database.NewDatabase()
+	meta, err := persist.OpenDataDir(*dataDir, *serverName, "")
 	if err != nil {
-		fmt.Println("Error while creating db")
+		logger.Error("failed to open data directory", "err", err)
+		return
+	}
+	logger.Info("starting", "cluster", meta.ClusterId, "data_dir", *dataDir, "peer_addr", *peerAddress, "client_addr", *clientAddress)
+
+	engine, err := openStorageEngine()
+	if err != nil {
+		logger.Error("failed to open storage engine", "err", err)
+		return
+	}
+	db, err := database.NewDatabase(engine)
+	if err != nil {
+		logger.Error("failed to create database", "err", err)
 		return
 	}
 
@@ -321,14 +620,19 @@ func main() {
 	electionTimeoutInterval := rand.Intn(int(ElectionMaxTimeout)-int(ElectionMinTimeout)) + int(ElectionMinTimeout)
 	electionModule := model.NewElectionModule(electionTimeoutInterval)
 
-	err = logger.AddServer(*serverName, *port)
+	transportSecurity, err := newTransportSecurity()
+	if err != nil {
+		logger.Error("failed to configure TLS", "err", err)
+		return
+	}
+
+	err = bootstrapRegistry(members)
 	if err != nil {
-		fmt.Println(err)
+		logger.Error("failed to register the initial cluster", "err", err)
 		return
 	}
 
 	s := Server{
-		port:           *port,
 		db:             db,
 		Logs:           db.RebuildLogIfExists(*serverName),
 		serverState:    model.GetExistingServerStateOrCreateNew(*serverName),
@@ -336,16 +640,79 @@ func main() {
 		leaderNodeId:   "",
 		peerdata:       model.NewPeerData(),
 		electionModule: electionModule,
+		pending:        make(map[int]proposal),
+		tls:            transportSecurity,
+		metrics:        newMetrics(),
+		log:            logger,
+		leaderCommit:   -1,
+		tracer:         tracer,
+		spans:          spans,
+		traces:         make(map[int]trace.SpanContext),
+		observer:       observer.New(),
+	}
+	if err := s.serverState.Persist(); err != nil {
+		logger.Error("failed to persist hard state", "err", err)
+		return
 	}
-	s.serverState.LogServerPersistedState()
+	s.applyCommittedEntries()
 	go s.electionTimer()
-	http.HandleFunc("/", s.handleConn)
 
-	err = http.ListenAndServe(":"+*port, nil)
-	if err != nil {
-		fmt.Println(err)
-		return
+	peerMux := http.NewServeMux()
+	registerPeerRoutes(peerMux, &s)
+
+	clientMux := http.NewServeMux()
+	clientMux.HandleFunc("/", s.handleConn)
+	registerV1Routes(clientMux, &s)
+	registerAuthRoutes(clientMux, &s)
+	registerClusterRoutes(clientMux, &s)
+	clientMux.HandleFunc("/metrics", s.handleMetrics)
+	registerStatusRoutes(clientMux, &s)
+	clientMux.HandleFunc("/v1/debug/log-level", s.handleLogLevel)
+	clientMux.HandleFunc("/v1/debug/traces", s.handleTraces)
+	clientMux.HandleFunc("/v1/events", s.handleEvents)
+	if *legacyAPI {
+		clientMux.HandleFunc("/txn", s.handleTxn)
+		clientMux.HandleFunc("/ttl", s.handleTTL)
+		clientMux.HandleFunc("/scan", s.handleScan)
+		clientMux.HandleFunc("/keys", s.handleScan)
+		clientMux.HandleFunc("/count", s.handleCount)
+		clientMux.HandleFunc("/watch", s.handleWatch)
+		clientMux.HandleFunc("/history", s.handleHistory)
+	}
+
+	errs := make(chan error, 2)
+	go func() { errs <- listen(*peerAddress, peerMux, s.tls.peerServerTLS()) }()
+	go func() { errs <- listen(*clientAddress, s.withLeaderHeader(clientMux), s.tls.clientTLS) }()
+	logger.Error("listener failed", "err", <-errs)
+}
+
+func openStorageEngine() (database.Engine, error) {
+	switch *storageEngine {
+	case "memory":
+		return database.NewMemoryEngine(), nil
+	case "disk":
+		path := *storagePath
+		if path == "" {
+			path = persist.DataPath(filepath.Join(persist.SnapshotDir, "kv.db"))
+		}
+		return database.OpenDiskEngine(path)
+	default:
+		return nil, fmt.Errorf("unknown storage engine %s", *storageEngine)
+	}
+}
+
+// applyCommittedEntries brings the state machine up to date with the committed
+// part of the log at startup, the storage engine only needs the entries after
+// the last one it has applied
+func (s *Server) applyCommittedEntries() {
+	appliedIndex := s.db.AppliedIndex()
+	if appliedIndex > len(s.Logs) {
+		appliedIndex = len(s.Logs)
+	}
+	for i := appliedIndex; i < s.serverState.CommitLength && i < len(s.Logs); i++ {
+		s.db.PerformDbOperations(i+1, strings.Split(s.Logs[i], "#")[0])
 	}
+	s.logger().Info("replayed committed log entries", "from", appliedIndex, "to", s.serverState.CommitLength)
 }
 
 func parseLogTerm(message string) int {
@@ -354,16 +721,47 @@ func parseLogTerm(message string) int {
 	return pTerm
 }
 
-func parseFlags() {
+// parseFlags parses the command line and the config file, and returns the initial cluster
+func parseFlags() []member {
 	flag.Parse()
+	if *configFile != "" {
+		if err := loadConfigFile(flag.CommandLine, *configFile); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
 
 	if *serverName == "" {
 		log.Fatalf("Must provide serverName for the server")
 	}
+	if *dataDir == "" {
+		*dataDir = *serverName + ".data"
+	}
 
-	if *port == "" {
-		log.Fatalf("Must provide a port number for server to run")
+	var members []member
+	if *initialCluster != "" {
+		var err error
+		if members, err = parseInitialCluster(*initialCluster); err != nil {
+			log.Fatalf("%v", err)
+		}
+		if err := ownAddresses(members); err != nil {
+			log.Fatalf("%v", err)
+		}
 	}
+
+	if *peerAddress == "" || *clientAddress == "" {
+		log.Fatalf("Must provide a peer address and a client address for server to run")
+	}
+	if *peerAddress == *clientAddress {
+		log.Fatalf("Peer address and client address must be different")
+	}
+
+	if *initialClusterState != "new" && *initialClusterState != "existing" {
+		log.Fatalf("Initial cluster state must be new or existing")
+	}
+	if *followerMode != "proxy" && *followerMode != "redirect" {
+		log.Fatalf("Follower mode must be proxy or redirect")
+	}
+	return members
 }
 
 func (s *Server) handleResponse(res *http.Response, addr string) error {
@@ -373,14 +771,17 @@ func (s *Server) handleResponse(res *http.Response, addr string) error {
 	}
 	defer res.Body.Close()
 
-	// Convert the request body to a string
-	data := string(body)
-	message := strings.TrimSpace(string(data))
-
-	if message == "invalid command" || message == "replication successful" {
+	data := strings.TrimSpace(string(body))
+	if data == "" {
 		return nil
 	}
-	fmt.Println(">", string(message))
+	envelope, _, err := s.openEnvelope(data)
+	if err != nil {
+		s.log.Warn("rejected raft reply", "peer_addr", addr, "err", err)
+		return err
+	}
+	message := envelope.Message
+	s.logger().Debug("received raft reply", "peer", envelope.SenderId, "message", message)
 
 	var response string = ""
 	if strings.HasPrefix(message, "LogRequest") {
@@ -396,23 +797,24 @@ func (s *Server) handleResponse(res *http.Response, addr string) error {
 		s.handleVoteResponse(message)
 	}
 
-	if s.currentRole == "leader" && response == "" {
-		var err = s.db.ValidateCommand(message)
+	if response != "" && response != "replication successful" {
+		reqBody := []byte(s.seal(response, envelope.SenderId, trace.SpanContext{}))
+		_, err = s.tls.peerClient(addr).Post(s.peerURL(addr, response), "text/plain", bytes.NewBuffer(reqBody))
 		if err != nil {
-			response = err.Error()
+			s.metrics.rpcFailed(envelope.SenderId, strings.SplitN(response, "|", 2)[0])
 		}
 	}
-
-	if response != "" {
-		reqBody := []byte(response)
-		_, err = http.Post(addr, "text/plain", bytes.NewBuffer(reqBody))
-	}
 	return err
 }
 
 func (s *Server) handleConn(w http.ResponseWriter, r *http.Request) {
 	var response string
 
+	if !*legacyAPI {
+		http.Error(w, "The text protocol is disabled, use the /v1 API", http.StatusNotFound)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodPost:
 		// Read the request body
@@ -426,59 +828,34 @@ func (s *Server) handleConn(w http.ResponseWriter, r *http.Request) {
 		data := string(body)
 
 		message := strings.TrimSpace(string(data))
-		if message == "invalid command" || message == "replication successful" {
+		if message == "invalid command" {
 			return
 		}
-		fmt.Println(">", string(message))
+		s.log.Debug("client command", "command", database.RedactCommand(message))
 
-		if strings.HasPrefix(message, "LogRequest") {
-			response = s.handleLogRequest(message)
+		message, err = sessionCommand(r, message)
+		if err == nil {
+			err = s.db.ValidateCommand(message)
 		}
-		if strings.HasPrefix(message, "LogResponse") {
-			response = s.handleLogResponse(message)
-		}
-		if strings.HasPrefix(message, "VoteRequest") {
-			response = s.handleVoteRequest(message)
-		}
-		if strings.HasPrefix(message, "VoteResponse") {
-			s.handleVoteResponse(message)
+		if err != nil {
+			response = err.Error()
+		} else if err := s.authorizeCommand(r, message); err != nil {
+			http.Error(w, err.Error(), authStatusCode(err))
+			return
 		}
 
 		if s.currentRole == "leader" && response == "" {
-			var err = s.db.ValidateCommand(message)
-			if err != nil {
-				response = err.Error()
-			}
-
-			if response == "" {
-				logMessage := message + "#" + strconv.Itoa(s.serverState.CurrentTerm)
-				s.peerdata.AckedLength[s.serverState.Name] = len(s.Logs)
-				s.Logs = append(s.Logs, logMessage)
-				currLogIdx := len(s.Logs) - 1
-				err = s.db.LogCommand(logMessage, s.serverState.Name)
-				if err != nil {
-					response = "error while logging command"
-				}
-
-				allServers, _ := logger.ListAllServers()
-				for sname, sport := range allServers {
-					s.replicateLog(sname, sport)
-				}
-
-				for s.serverState.CommitLength <= currLogIdx {
-					fmt.Println("Waiting for consensus: ")
-				}
-				response = "operation sucessful"
-			}
+			response = s.proposeRequest(r, message).String()
 		} else if s.currentRole != "leader" && response == "" {
 
-			allServers, _ := logger.ListAllServers()
-			fmt.Println("Current leader:", s.leaderNodeId)
-			resp, err := http.Post("http://localhost:"+strconv.Itoa(allServers[s.leaderNodeId]),
-				"text/plain", bytes.NewBuffer(body))
+			if s.redirectToLeader(w, r) {
+				return
+			}
+			s.log.Debug("forwarding to the leader", "leader", s.leaderNodeId)
+			resp, err := s.postToLeader(r, message)
 
 			if err != nil {
-				http.Error(w, "Error redirecting request", http.StatusBadRequest)
+				s.writeNotLeader(w, r, http.StatusServiceUnavailable, "error redirecting request to the leader")
 				return
 			}
 
@@ -494,60 +871,67 @@ func (s *Server) handleConn(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		queryParams := r.URL.Query()
 		key := queryParams.Get("key")
-		fmt.Println(">", "GET", key)
-		response = s.db.PerformGet(key)
+		s.log.Debug("client command", "command", "GET "+key)
+		if err := s.authorizeRead(r, key); err != nil {
+			http.Error(w, err.Error(), authStatusCode(err))
+			return
+		}
+		if queryParams.Get("rev") != "" {
+			rev, err := strconv.Atoi(queryParams.Get("rev"))
+			if err != nil {
+				http.Error(w, "not a valid revision", http.StatusBadRequest)
+				return
+			}
+			response = s.db.PerformGetAt(key, rev)
+		} else {
+			response = s.db.PerformGet(key)
+		}
 
 	case http.MethodDelete:
 		queryParams := r.URL.Query()
 		key := queryParams.Get("key")
 
-		fmt.Println(">", "DELETE", key)
-		message := "DELETE " + key
+		s.log.Debug("client command", "command", "DELETE "+key)
+		message, err := sessionCommand(r, "DELETE "+key)
+		if err == nil {
+			err = s.db.ValidateCommand(message)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.authorizeCommand(r, message); err != nil {
+			http.Error(w, err.Error(), authStatusCode(err))
+			return
+		}
 
 		if s.currentRole == "leader" && response == "" {
-			var err error
-
-			if response == "" {
-				logMessage := message + "#" + strconv.Itoa(s.serverState.CurrentTerm)
-				s.peerdata.AckedLength[s.serverState.Name] = len(s.Logs)
-				s.Logs = append(s.Logs, logMessage)
-				currLogIdx := len(s.Logs) - 1
-				err = s.db.LogCommand(logMessage, s.serverState.Name)
-				if err != nil {
-					response = "error while logging command"
-				}
-
-				allServers, _ := logger.ListAllServers()
-				for sname, sport := range allServers {
-					s.replicateLog(sname, sport)
-				}
-
-				for s.serverState.CommitLength <= currLogIdx {
-					fmt.Println("Waiting for consensus: ")
-				}
-				response = "operation sucessful"
-			}
+			response = s.proposeRequest(r, message).String()
 		} else if s.currentRole != "leader" && response == "" {
+			if s.redirectToLeader(w, r) {
+				return
+			}
+			s.log.Debug("forwarding to the leader", "leader", s.leaderNodeId)
 
-			allServers, _ := logger.ListAllServers()
-			fmt.Println("Current leader:", s.leaderNodeId)
-
-			baseURL := "http://localhost:" + strconv.Itoa(allServers[s.leaderNodeId])
+			baseURL := s.leaderURL()
 			parameters := url.Values{}
 			parameters.Add("key", key)
 			url := fmt.Sprintf("%s?%s", baseURL, parameters.Encode())
 
 			req, err := http.NewRequest("DELETE", url, nil)
 			if err != nil {
-				fmt.Println("Error:", err)
+				s.log.Error("failed to forward to the leader", "err", err)
 				return
 			}
+			req.Header.Set(ClientIdHeader, r.Header.Get(ClientIdHeader))
+			req.Header.Set(ClientSeqHeader, r.Header.Get(ClientSeqHeader))
+			req.Header.Set("Authorization", r.Header.Get("Authorization"))
+			req.Header.Set(TraceparentHeader, r.Header.Get(TraceparentHeader))
 
-			client := &http.Client{}
-			resp, err := client.Do(req)
+			resp, err := s.tls.leaderHTTP.Do(req)
 
 			if err != nil {
-				http.Error(w, "Error redirecting request", http.StatusBadRequest)
+				s.writeNotLeader(w, r, http.StatusServiceUnavailable, "error redirecting request to the leader")
 				return
 			}
 
@@ -566,3 +950,254 @@ func (s *Server) handleConn(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(response + "\n"))
 	}
 }
+
+// postToLeader sends a text command to the leader on behalf of the client of r
+func (s *Server) postToLeader(r *http.Request, command string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, s.leaderURL(), bytes.NewBufferString(command))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Authorization", r.Header.Get("Authorization"))
+	req.Header.Set(TraceparentHeader, r.Header.Get(TraceparentHeader))
+	return s.tls.leaderHTTP.Do(req)
+}
+
+// sessionCommand wraps command in the client session given by the request
+// headers, requests without a client id are returned as is
+func sessionCommand(r *http.Request, command string) (string, error) {
+	clientId := r.Header.Get(ClientIdHeader)
+	if clientId == "" || strings.HasPrefix(command, "SESSION ") {
+		return command, nil
+	}
+	if strings.ContainsAny(clientId, " #,|") {
+		return "", fmt.Errorf("invalid client id %q", clientId)
+	}
+	seq, err := strconv.Atoi(r.Header.Get(ClientSeqHeader))
+	if err != nil || seq <= 0 {
+		return "", fmt.Errorf("invalid %s header", ClientSeqHeader)
+	}
+	return database.WithSession(clientId, seq, command), nil
+}
+
+// handleTxn accepts a transaction as JSON and turns it into a single TXN command
+// that is proposed by the leader or redirected to it
+func (s *Server) handleTxn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST is supported for transactions", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	txn, err := database.ParseTxn(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	command, err := txn.Command()
+	if err != nil {
+		http.Error(w, "Error encoding transaction", http.StatusInternalServerError)
+		return
+	}
+	command, err = sessionCommand(r, command)
+	if err == nil {
+		err = s.db.ValidateCommand(command)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.authorizeCommand(r, command); err != nil {
+		http.Error(w, err.Error(), authStatusCode(err))
+		return
+	}
+	s.log.Debug("client command", "command", "TXN", "txn", string(body))
+
+	var response string
+	if s.currentRole == "leader" {
+		response = s.proposeRequest(r, command).String()
+	} else {
+		if s.redirectToLeader(w, r) {
+			return
+		}
+		s.log.Debug("forwarding to the leader", "leader", s.leaderNodeId)
+		resp, err := s.postToLeader(r, command)
+		if err != nil {
+			s.writeNotLeader(w, r, http.StatusServiceUnavailable, "error redirecting request to the leader")
+			return
+		}
+		defer resp.Body.Close()
+
+		respData, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+		response = strings.TrimSpace(string(respData))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(response + "\n"))
+}
+
+func (s *Server) handleTTL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported for TTL", http.StatusMethodNotAllowed)
+		return
+	}
+	key := r.URL.Query().Get("key")
+	s.log.Debug("client command", "command", "TTL "+key)
+	if err := s.authorizeRead(r, key); err != nil {
+		http.Error(w, err.Error(), authStatusCode(err))
+		return
+	}
+	w.Write([]byte(s.db.PerformTTL(key) + "\n"))
+}
+
+// handleScan serves both /scan?start=&end=&limit= and /keys?prefix=&limit=, a page
+// is continued by passing the next_token of the previous one as token. Range
+// listings of the v1 API (/v1/kv/?prefix=) are served by it as well
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported for scans", http.StatusMethodNotAllowed)
+		return
+	}
+	queryParams := r.URL.Query()
+	start, end := queryParams.Get("start"), queryParams.Get("end")
+	if queryParams.Has("prefix") {
+		start = queryParams.Get("prefix")
+		end = database.PrefixEnd(start)
+	}
+	if token := queryParams.Get("token"); token != "" {
+		next, err := database.DecodePageToken(token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if next > start {
+			start = next
+		}
+	}
+	limit := 0
+	if queryParams.Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(queryParams.Get("limit"))
+		if err != nil {
+			http.Error(w, "not a valid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	s.log.Debug("client command", "command", "SCAN", "start", start, "end", end, "limit", limit)
+	if err := s.authorizeRange(r, start, end); err != nil {
+		http.Error(w, err.Error(), authStatusCode(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.db.Scan(start, end, limit))
+}
+
+func (s *Server) handleCount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported for COUNT", http.StatusMethodNotAllowed)
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	s.log.Debug("client command", "command", "COUNT", "prefix", prefix)
+	if err := s.authorizeRange(r, prefix, database.PrefixEnd(prefix)); err != nil {
+		http.Error(w, err.Error(), authStatusCode(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"count": s.db.Count(prefix)})
+}
+
+// handleWatch streams the events of a key, or of a prefix with prefix=true, as
+// one JSON object per line for as long as the client stays connected. Passing
+// from=<index> resumes a watch after the last event a client has seen. Every
+// node applies the committed log so watches can be served by followers as well
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported for watches", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	queryParams := r.URL.Query()
+	key := queryParams.Get("key")
+	if strings.HasPrefix(r.URL.Path, "/v1/watch/") {
+		key = strings.TrimPrefix(r.URL.Path, "/v1/watch/")
+	}
+	prefix := queryParams.Get("prefix") == "true"
+	fromIndex := 0
+	if queryParams.Get("from") != "" {
+		var err error
+		fromIndex, err = strconv.Atoi(queryParams.Get("from"))
+		if err != nil {
+			http.Error(w, "not a valid index", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := s.authorizeRead(r, key)
+	if prefix {
+		err = s.authorizeRange(r, key, database.PrefixEnd(key))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), authStatusCode(err))
+		return
+	}
+
+	watcher, err := s.db.Watch(key, prefix, fromIndex)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	defer s.db.Unwatch(watcher)
+	s.log.Debug("client command", "command", "WATCH "+key, "prefix", prefix, "from_index", fromIndex)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-watcher.Events():
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported for HISTORY", http.StatusMethodNotAllowed)
+		return
+	}
+	key := r.URL.Query().Get("key")
+	s.log.Debug("client command", "command", "HISTORY "+key)
+	if err := s.authorizeRead(r, key); err != nil {
+		http.Error(w, err.Error(), authStatusCode(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(s.db.PerformHistory(key) + "\n"))
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ssergomol/raft/database"
+	"github.com/ssergomol/raft/model"
+	"github.com/ssergomol/raft/observer"
+	"github.com/ssergomol/raft/persist"
+	"github.com/ssergomol/raft/trace"
+)
+
+// newTestServer returns a follower named name with a data directory of its
+// own and no peers, it's only driven by calling its handlers. The data
+// directory is the one of every node created before it too
+func newTestServer(t *testing.T, name string) (*Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	if _, err := persist.OpenDataDir(dir, name, "test"); err != nil {
+		t.Fatalf("OpenDataDir: %v", err)
+	}
+	electionModule := model.NewElectionModule(int(ElectionMaxTimeout))
+	t.Cleanup(electionModule.ElectionTimeout.Stop)
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case <-electionModule.ResetElectionTimer:
+			case <-done:
+				return
+			}
+		}
+	}()
+	db, err := database.NewDatabase(database.NewMemoryEngine())
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	s := &Server{
+		db:             db,
+		serverState:    model.GetExistingServerStateOrCreateNew(name),
+		currentRole:    "follower",
+		peerdata:       model.NewPeerData(),
+		electionModule: electionModule,
+		pending:        make(map[int]proposal),
+		tls:            &transportSecurity{},
+		metrics:        newMetrics(),
+		log:            slog.Default(),
+		leaderCommit:   -1,
+		traces:         make(map[int]trace.SpanContext),
+		observer:       observer.New(),
+	}
+	return s, dir
+}
+
+func requestVote(t *testing.T, s *Server, candidateId string, term int) *model.VoteResponse {
+	t.Helper()
+	response := s.handleVoteRequest(model.NewVoteRequest(candidateId, term, 0, 0).String())
+	if response == "" {
+		return nil
+	}
+	voteResponse, err := model.ParseVoteResponse(response)
+	if err != nil {
+		t.Fatalf("ParseVoteResponse(%q): %v", response, err)
+	}
+	return voteResponse
+}
+
+func TestVoteSurvivesCrash(t *testing.T) {
+	s, _ := newTestServer(t, "n1")
+	if vote := requestVote(t, s, "n2", 1); vote == nil || !vote.VoteInFavor {
+		t.Fatalf("vote for n2 in term 1 = %+v, want granted", vote)
+	}
+
+	// the node crashes right after answering and restarts from its data directory
+	s.serverState = model.GetExistingServerStateOrCreateNew("n1")
+	if s.serverState.CurrentTerm != 1 || s.serverState.VotedFor != "n2" {
+		t.Fatalf("hard state after restart = %+v, want term 1 voted for n2", s.serverState)
+	}
+	if vote := requestVote(t, s, "n3", 1); vote == nil || vote.VoteInFavor {
+		t.Fatalf("vote for n3 in term 1 after restart = %+v, want refused", vote)
+	}
+	if vote := requestVote(t, s, "n2", 1); vote == nil || !vote.VoteInFavor {
+		t.Fatalf("repeated vote for n2 in term 1 = %+v, want granted again", vote)
+	}
+}
+
+func TestNoVoteWithoutPersistedState(t *testing.T) {
+	s, dir := newTestServer(t, "n1")
+	// every write to the data directory fails from now on
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if vote := requestVote(t, s, "n2", 1); vote != nil {
+		t.Fatalf("vote for n2 = %+v, want no response when the hard state can't be persisted", vote)
+	}
+}
+
+func TestNewTermForgetsLeader(t *testing.T) {
+	s, _ := newTestServer(t, "n1")
+	s.serverState.CurrentTerm = 1
+	s.leaderNodeId = "n2"
+	requestVote(t, s, "n3", 2)
+	if s.leaderNodeId != "" {
+		t.Fatalf("leader in term 2 = %q, want none until it's heard from", s.leaderNodeId)
+	}
+}
+
+func TestConcurrentCommitsApplyEachEntryOnce(t *testing.T) {
+	s, _ := newTestServer(t, "n1")
+	s.currentRole = "leader"
+	results := make([]chan database.Result, 20)
+	for i := range results {
+		s.Logs = append(s.Logs, "SETNX k "+strconv.Itoa(i)+"#0")
+		results[i] = make(chan database.Result, 1)
+		s.pending[i] = proposal{term: 0, result: results[i]}
+	}
+
+	// every log response commits from a goroutine of its own
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.commitLogEntries()
+		}()
+	}
+	wg.Wait()
+
+	if s.serverState.CommitLength != len(results) || s.db.AppliedIndex() != len(results) {
+		t.Fatalf("commit length %d, applied index %d, want %d", s.serverState.CommitLength, s.db.AppliedIndex(), len(results))
+	}
+	for i, result := range results {
+		got := <-result
+		want := database.StatusConditionFailed
+		if i == 0 {
+			want = database.StatusOK
+		}
+		if got.Status != want || got.Message == "" {
+			t.Fatalf("result of entry %d = %+v, want status %v from its apply", i+1, got, want)
+		}
+	}
+}
+
+func TestV1RejectsControlCharactersInKeys(t *testing.T) {
+	s, _ := newTestServer(t, "n1")
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		r := httptest.NewRequest(method, "/v1/kv/a%0Ab", strings.NewReader(`{"value":1}`))
+		w := httptest.NewRecorder()
+		s.handleV1KV(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s /v1/kv/a%%0Ab = %d, want %d", method, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestOpenEnvelope(t *testing.T) {
+	s, _ := newTestServer(t, "n1")
+	if err := persist.AddServer("n2", "127.0.0.1:7002", "127.0.0.1:8002"); err != nil {
+		t.Fatal(err)
+	}
+	vote := model.NewVoteRequest("n2", 1, 0, 0).String()
+	tests := []struct {
+		name     string
+		envelope string
+		status   int
+	}{
+		{"member of the cluster", model.NewEnvelope("test", "n2", "n1", vote).String(), http.StatusOK},
+		{"other recipient", model.NewEnvelope("test", "n2", "n3", vote).String(), http.StatusMisdirectedRequest},
+		{"other cluster", model.NewEnvelope("other", "n2", "n1", vote).String(), http.StatusForbidden},
+		{"no cluster", model.NewEnvelope("", "n2", "n1", vote).String(), http.StatusForbidden},
+		{"not a member", model.NewEnvelope("test", "n4", "n1", model.NewVoteRequest("n4", 1, 0, 0).String()).String(), http.StatusForbidden},
+		{"message of another sender", model.NewEnvelope("test", "n2", "n1", model.NewVoteRequest("n3", 1, 0, 0).String()).String(), http.StatusBadRequest},
+		{"no envelope", vote, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if _, status, err := s.openEnvelope(tt.envelope); status != tt.status {
+			t.Errorf("%s: openEnvelope = %d (%v), want %d", tt.name, status, err, tt.status)
+		}
+	}
+}
+
+func TestJoiningNodeLearnsClusterId(t *testing.T) {
+	s, _ := newTestServer(t, "n1")
+	if err := persist.AddServer("n2", "127.0.0.1:7002", "127.0.0.1:8002"); err != nil {
+		t.Fatal(err)
+	}
+	if err := persist.SetClusterId(""); err != nil {
+		t.Fatal(err)
+	}
+	// only the leader tells the cluster ID
+	vote := model.NewVoteRequest("n2", 1, 0, 0).String()
+	if _, status, _ := s.openEnvelope(model.NewEnvelope("c1", "n2", "n1", vote).String()); status != http.StatusForbidden {
+		t.Fatalf("vote request of cluster c1 = %d, want %d", status, http.StatusForbidden)
+	}
+	logRequest := model.NewLogRequest("n2", 1, 0, 0, 0, nil).String()
+	if _, status, err := s.openEnvelope(model.NewEnvelope("c1", "n2", "n1", logRequest).String()); err != nil {
+		t.Fatalf("log request of cluster c1 = %d (%v), want accepted", status, err)
+	}
+	if persist.ClusterId() != "c1" {
+		t.Fatalf("cluster ID = %q, want c1", persist.ClusterId())
+	}
+	if _, status, _ := s.openEnvelope(model.NewEnvelope("c2", "n2", "n1", logRequest).String()); status != http.StatusForbidden {
+		t.Fatalf("log request of cluster c2 = %d, want %d", status, http.StatusForbidden)
+	}
+}
+
+// bootstrapCluster has a new single node cluster named name elect itself and
+// returns the cluster ID it generates
+func bootstrapCluster(t *testing.T, name string) string {
+	t.Helper()
+	s, _ := newTestServer(t, name)
+	if err := persist.SetClusterId(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := persist.AddServer(name, "127.0.0.1:7001", "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+	s.currentRole = "leader"
+	s.identifyCluster()
+	if persist.ClusterId() == "" {
+		t.Fatal("no cluster ID after the first election")
+	}
+	// a later leader keeps it
+	id := persist.ClusterId()
+	s.identifyCluster()
+	if persist.ClusterId() != id || len(s.Logs) != 1 {
+		t.Fatalf("cluster ID %q and %d entries after the next election, want %q and 1", persist.ClusterId(), len(s.Logs), id)
+	}
+	return id
+}
+
+func TestFirstLeaderGeneratesClusterId(t *testing.T) {
+	if first, second := bootstrapCluster(t, "n1"), bootstrapCluster(t, "n1"); first == second {
+		t.Fatalf("two clusters with the same members got the same ID %s", first)
+	}
+}
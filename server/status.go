@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/ssergomol/raft/persist"
+)
+
+// Probes and status of the node on the client listener, none of them needs
+// authentication:
+//
+//	GET /status  JSON with the raft state of the node and the replication
+//	             progress of its peers, which only the leader keeps up to date
+//	GET /health  200 as long as the node serves requests
+//	GET /ready   200 once a leader is known and the node has applied the
+//	             entries the leader had committed when the node started, 503 before
+//
+// The log is never truncated from the front, so its first index is 1 as soon
+// as it has an entry
+
+type peerStatus struct {
+	Id         string `json:"id"`
+	MatchIndex int    `json:"match_index"`
+	NextIndex  int    `json:"next_index"`
+	Suspected  bool   `json:"suspected"`
+}
+
+type nodeStatus struct {
+	NodeId        string       `json:"node_id"`
+	ClusterId     string       `json:"cluster_id"`
+	Role          string       `json:"role"`
+	Term          int          `json:"term"`
+	LeaderId      string       `json:"leader_id"`
+	CommitIndex   int          `json:"commit_index"`
+	AppliedIndex  int          `json:"applied_index"`
+	LogFirstIndex int          `json:"log_first_index"`
+	LogLastIndex  int          `json:"log_last_index"`
+	Peers         []peerStatus `json:"peers"`
+}
+
+func registerStatusRoutes(mux *http.ServeMux, s *Server) {
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/ready", s.handleReady)
+}
+
+func (s *Server) status() nodeStatus {
+	status := nodeStatus{
+		NodeId:       s.serverState.Name,
+		ClusterId:    persist.ClusterId(),
+		Role:         s.currentRole,
+		Term:         s.serverState.CurrentTerm,
+		LeaderId:     s.leaderNodeId,
+		CommitIndex:  s.serverState.CommitLength,
+		AppliedIndex: s.db.AppliedIndex(),
+		LogLastIndex: len(s.Logs),
+		Peers:        []peerStatus{},
+	}
+	if status.LogLastIndex > 0 {
+		status.LogFirstIndex = 1
+	}
+	progress := s.peerdata.Progress()
+	allServers, _ := persist.ListAllServers()
+	for name, addr := range allServers {
+		if name == s.serverState.Name {
+			continue
+		}
+		status.Peers = append(status.Peers, peerStatus{
+			Id:         name,
+			MatchIndex: progress.AckedLength[name],
+			NextIndex:  progress.SentLength[name],
+			Suspected:  progress.SuspectedNodes[addr],
+		})
+	}
+	sort.Slice(status.Peers, func(i, j int) bool { return status.Peers[i].Id < status.Peers[j].Id })
+	return status
+}
+
+// ready tells if the node knows a leader and has caught up with the commit
+// index the leader had when the node started, once caught up it stays so
+func (s *Server) ready() bool {
+	if s.leaderNodeId == "" {
+		return false
+	}
+	if s.caughtUp {
+		return true
+	}
+	leaderCommit := s.leaderCommit
+	if s.leaderNodeId == s.serverState.Name {
+		leaderCommit = s.serverState.CommitLength
+	}
+	if leaderCommit < 0 || s.db.AppliedIndex() < leaderCommit {
+		return false
+	}
+	s.caughtUp = true
+	return true
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported for the status")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported for health checks")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported for readiness checks")
+		return
+	}
+	if !s.ready() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ssergomol/raft/persist"
+)
+
+// Peer RPCs use mutual TLS once a peer certificate is configured: every node
+// presents its certificate both as a server and as a client, and a peer is
+// only accepted if its certificate is valid for the node ID it claims, the
+// DNS names or the common name of the certificate must include the node ID.
+// The client listener serves TLS once a client certificate is configured and
+// also requires client certificates when a trusted CA is given. Followers
+// verify the leader's client certificate when they forward writes to it with
+// -leader-trusted-ca-file, or else the client or the peer trusted CA, and only
+// fall back to the system roots when none is given. Certificate and key files
+// are reloaded when they change on disk, so certificates can be rotated
+// without a restart
+
+// certReloader serves a certificate and key pair, loading it again whenever one
+// of the files is modified
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("need both a certificate and a key file")
+	}
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := c.certificate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// certificate returns the current certificate, the last one loaded is kept if
+// the files can't be read, e.g. while they are being replaced
+func (c *certReloader) certificate() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTime, err := c.latestModTime()
+	if err == nil && (c.cert == nil || !modTime.Equal(c.modTime)) {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err == nil {
+			if c.cert != nil {
+				slog.Info("certificate reloaded", "cert_file", c.certFile)
+			}
+			c.cert = &cert
+			c.modTime = modTime
+		}
+	}
+	if c.cert == nil {
+		return nil, err
+	}
+	return c.cert, nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.certificate()
+}
+
+func (c *certReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.certificate()
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// transportSecurity holds the TLS configuration of both listeners and the HTTP
+// clients used to reach other nodes
+type transportSecurity struct {
+	peerCert  *certReloader
+	peerCAs   *x509.CertPool
+	clientTLS *tls.Config
+
+	mu          sync.Mutex
+	peerClients map[string]*http.Client
+	leaderHTTP  *http.Client
+}
+
+func newTransportSecurity() (*transportSecurity, error) {
+	t := &transportSecurity{peerClients: make(map[string]*http.Client), leaderHTTP: &http.Client{Timeout: *proxyTimeout}}
+	var err error
+
+	if *peerCertFile != "" || *peerKeyFile != "" {
+		if *peerTrustedCAFile == "" {
+			return nil, errors.New("peer TLS needs -peer-trusted-ca-file")
+		}
+		if t.peerCert, err = newCertReloader(*peerCertFile, *peerKeyFile); err != nil {
+			return nil, err
+		}
+		if t.peerCAs, err = loadCertPool(*peerTrustedCAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if *clientCertFile != "" || *clientKeyFile != "" {
+		clientCert, err := newCertReloader(*clientCertFile, *clientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		t.clientTLS = &tls.Config{GetCertificate: clientCert.getCertificate, MinVersion: tls.VersionTLS12}
+		forwardTLS := &tls.Config{GetClientCertificate: clientCert.getClientCertificate, MinVersion: tls.VersionTLS12}
+		if *clientTrustedCAFile != "" {
+			clientCAs, err := loadCertPool(*clientTrustedCAFile)
+			if err != nil {
+				return nil, err
+			}
+			t.clientTLS.ClientCAs = clientCAs
+			t.clientTLS.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		for _, caFile := range []string{*leaderTrustedCAFile, *clientTrustedCAFile, *peerTrustedCAFile} {
+			if caFile == "" {
+				continue
+			}
+			if forwardTLS.RootCAs, err = loadCertPool(caFile); err != nil {
+				return nil, err
+			}
+			break
+		}
+		t.leaderHTTP.Transport = &http.Transport{TLSClientConfig: forwardTLS}
+	} else if *clientTrustedCAFile != "" || *leaderTrustedCAFile != "" {
+		return nil, errors.New("client certificate authentication needs -client-cert-file")
+	}
+	return t, nil
+}
+
+func (t *transportSecurity) peerTLSEnabled() bool {
+	return t.peerCert != nil
+}
+
+// peerServerTLS is the configuration of the peer listener, the node ID of a
+// verified certificate is checked against the message in handlePeerMessage
+func (t *transportSecurity) peerServerTLS() *tls.Config {
+	if !t.peerTLSEnabled() {
+		return nil
+	}
+	return &tls.Config{
+		GetCertificate: t.peerCert.getCertificate,
+		ClientCAs:      t.peerCAs,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// peerClient returns the HTTP client for the peer at addr, with TLS the
+// peer's certificate has to be valid for the node ID it's registered with
+func (t *transportSecurity) peerClient(addr string) *http.Client {
+	if !t.peerTLSEnabled() {
+		return http.DefaultClient
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if client, ok := t.peerClients[addr]; ok {
+		return client
+	}
+	nodeId := ""
+	allServers, _ := persist.ListAllServers()
+	for name, peer := range allServers {
+		if peer == addr {
+			nodeId = name
+		}
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		GetClientCertificate: t.peerCert.getClientCertificate,
+		RootCAs:              t.peerCAs,
+		ServerName:           nodeId,
+		MinVersion:           tls.VersionTLS12,
+	}}}
+	if nodeId != "" {
+		t.peerClients[addr] = client
+	}
+	return client
+}
+
+func (t *transportSecurity) peerScheme() string {
+	if t.peerTLSEnabled() {
+		return "https"
+	}
+	return "http"
+}
+
+func (t *transportSecurity) clientScheme() string {
+	if t.clientTLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// verifyPeer checks that a peer request comes from the node its message was
+// sent by, it always succeeds without peer TLS
+func (t *transportSecurity) verifyPeer(r *http.Request, nodeId string) error {
+	if !t.peerTLSEnabled() {
+		return nil
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return errors.New("no peer certificate")
+	}
+	cert := r.TLS.PeerCertificates[0]
+	if cert.VerifyHostname(nodeId) == nil || (len(cert.DNSNames) == 0 && cert.Subject.CommonName == nodeId) {
+		return nil
+	}
+	return fmt.Errorf("certificate of %s isn't valid for node %s", cert.Subject.CommonName, nodeId)
+}
+
+// listen serves handler on addr, with TLS when tlsConfig is set
+func listen(addr string, handler http.Handler, tlsConfig *tls.Config) error {
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+	if tlsConfig == nil {
+		return server.ListenAndServe()
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// messageSender returns the node ID a raft message was sent by
+func messageSender(message string) string {
+	splits := strings.Split(message, "|")
+	if len(splits) < 2 {
+		return ""
+	}
+	return splits[1]
+}
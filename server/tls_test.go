@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA signs the certificates of a test, every certificate is generated when
+// the test runs
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+var serialNumber int64
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialNumber++
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serialNumber),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, file, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue writes a certificate for name, valid for the DNS names and 127.0.0.1,
+// and its key to certFile and keyFile
+func (ca *testCA) issue(t *testing.T, name string, dnsNames []string, certFile string, keyFile string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialNumber++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func writePEM(t *testing.T, file string, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyPeer(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "n1.pem"), filepath.Join(dir, "n1-key.pem")
+	ca.issue(t, "n1", []string{"n1"}, certFile, keyFile)
+	peerCert, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &transportSecurity{peerCert: peerCert}
+
+	n2 := ca.issue(t, "n2", []string{"n2"}, filepath.Join(dir, "n2.pem"), filepath.Join(dir, "n2-key.pem"))
+	n3CN := ca.issue(t, "n3", nil, filepath.Join(dir, "n3.pem"), filepath.Join(dir, "n3-key.pem"))
+	tests := []struct {
+		name   string
+		cert   *x509.Certificate
+		sender string
+		ok     bool
+	}{
+		{"DNS name matches sender", n2, "n2", true},
+		{"DNS name differs from sender", n2, "n3", false},
+		{"common name matches sender", n3CN, "n3", true},
+		{"common name differs from sender", n3CN, "n2", false},
+		{"no certificate", nil, "n2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/raft/LogRequest", nil)
+			r.TLS = &tls.ConnectionState{}
+			if tt.cert != nil {
+				r.TLS.PeerCertificates = []*x509.Certificate{tt.cert}
+			}
+			err := transport.verifyPeer(r, tt.sender)
+			if (err == nil) != tt.ok {
+				t.Fatalf("verifyPeer(%s) = %v, want ok %v", tt.sender, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCertReloaderPicksUpRewrittenFile(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "node.pem"), filepath.Join(dir, "node-key.pem")
+	first := ca.issue(t, "n1", []string{"n1"}, certFile, keyFile)
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := ca.issue(t, "n1", []string{"n1"}, certFile, keyFile)
+	// the rewrite may land within the resolution of the file system clock
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cert, err := reloader.certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	if leaf.SerialNumber.Cmp(second.SerialNumber) != 0 {
+		t.Fatalf("serial number = %v, want the rewritten %v and not %v", leaf.SerialNumber, second.SerialNumber, first.SerialNumber)
+	}
+
+	// a file being replaced keeps the last certificate in use
+	os.Remove(certFile)
+	if cert, err := reloader.certificate(); err != nil || cert == nil {
+		t.Fatalf("certificate() = %v, %v while the file is missing", cert, err)
+	}
+}
+
+func TestForwardingTrustsPeerCA(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	ca.issue(t, "n1", []string{"n1"}, certFile, keyFile)
+
+	leader := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	leaderCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader.TLS = &tls.Config{Certificates: []tls.Certificate{leaderCert}}
+	leader.StartTLS()
+	defer leader.Close()
+
+	for flag, value := range map[*string]string{clientCertFile: certFile, clientKeyFile: keyFile, peerTrustedCAFile: ca.file} {
+		previous := *flag
+		*flag = value
+		defer func(flag *string) { *flag = previous }(flag)
+	}
+	transport, err := newTransportSecurity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := transport.leaderHTTP.Get(leader.URL)
+	if err != nil {
+		t.Fatalf("forwarding to the leader with only -client-cert-file: %v", err)
+	}
+	resp.Body.Close()
+}
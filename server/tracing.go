@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/ssergomol/raft/trace"
+)
+
+// A client write is traced from the request through the append to the
+// leader's log, the LogRequest to every follower and the append there, to the
+// commit and the apply on the leader:
+//
+//	raft.propose
+//	├── raft.append
+//	├── raft.replicate        peer, entries
+//	│   └── raft.follower.append
+//	└── raft.commit           index, acks
+//	    └── raft.apply
+//
+// A client joins its own trace by sending a W3C traceparent header, followers
+// forward it with the writes they proxy to the leader and the leader puts the
+// context of its replication span in the envelope of the LogRequest. The
+// spans go where -trace-exporter says:
+//
+//	none    nothing is recorded, the default
+//	log     every span is logged at debug level
+//	memory  the last spans are kept and served on GET /v1/debug/traces,
+//	        filtered by ?trace_id=
+//
+// Reading the kept spans needs the root role once authentication is enabled
+
+// TraceparentHeader carries the W3C trace context of a client request
+const TraceparentHeader = "Traceparent"
+
+// keptSpans is the number of spans the memory exporter keeps
+const keptSpans = 1000
+
+// logExporter logs every span it's handed
+type logExporter struct {
+	log *slog.Logger
+}
+
+func (e logExporter) Export(span trace.SpanData) {
+	args := []any{"name", span.Name, "trace_id", span.Context.TraceId, "span_id", span.Context.SpanId,
+		"parent_id", span.Parent.SpanId, "duration", span.Duration()}
+	for k, v := range span.Attributes {
+		args = append(args, k, v)
+	}
+	e.log.Debug("span", args...)
+}
+
+// newTracer creates the tracer of the node from -trace-exporter, along with
+// the exporter keeping its spans in memory if that's the one asked for
+func newTracer(logger *slog.Logger) (*trace.Tracer, *trace.InMemoryExporter, error) {
+	switch *traceExporter {
+	case "none":
+		return nil, nil, nil
+	case "log":
+		return trace.NewTracer(logExporter{log: logger}), nil, nil
+	case "memory":
+		spans := trace.NewInMemoryExporter(keptSpans)
+		return trace.NewTracer(spans), spans, nil
+	default:
+		return nil, nil, fmt.Errorf("trace exporter must be none, log or memory")
+	}
+}
+
+// requestTrace returns the trace context sent by the client of r, an invalid
+// one if the request isn't traced
+func requestTrace(r *http.Request) trace.SpanContext {
+	return trace.ParseTraceparent(r.Header.Get(TraceparentHeader))
+}
+
+func (s *Server) handleTraces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported for traces")
+		return
+	}
+	if !s.authorizeRoot(w, r) {
+		return
+	}
+	if s.spans == nil {
+		writeError(w, http.StatusNotFound, "spans are only kept with -trace-exporter memory")
+		return
+	}
+	traceId := r.URL.Query().Get("trace_id")
+	spans := []trace.SpanData{}
+	for _, span := range s.spans.Spans() {
+		if traceId == "" || span.Context.TraceId == traceId {
+			spans = append(spans, span)
+		}
+	}
+	writeJSON(w, http.StatusOK, spans)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ssergomol/raft/persist"
+	"github.com/ssergomol/raft/trace"
+)
+
+func TestProposalTrace(t *testing.T) {
+	spans := trace.NewInMemoryExporter(0)
+	leader, _ := newTestServer(t, "n1")
+	follower, _ := newTestServer(t, "n2")
+	leader.tracer = trace.NewTracer(spans)
+	follower.tracer = trace.NewTracer(spans)
+	leader.currentRole = "leader"
+	leader.leaderNodeId = "n1"
+
+	peerMux := http.NewServeMux()
+	registerPeerRoutes(peerMux, follower)
+	followerPeer := httptest.NewServer(peerMux)
+	defer followerPeer.Close()
+	if err := persist.AddServer("n1", "127.0.0.1:1", "127.0.0.1:2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := persist.AddServer("n2", strings.TrimPrefix(followerPeer.URL, "http://"), "127.0.0.1:3"); err != nil {
+		t.Fatal(err)
+	}
+
+	client := trace.ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	if result := leader.propose(context.Background(), client, "SET k 1"); result.Value != 1 {
+		t.Fatalf("propose = %+v, want k set to 1", result)
+	}
+
+	byName := map[string]trace.SpanData{}
+	for _, span := range spans.Spans() {
+		if _, ok := byName[span.Name]; ok {
+			t.Fatalf("span %s was exported twice", span.Name)
+		}
+		byName[span.Name] = span
+	}
+	parents := []struct {
+		span   string
+		parent string
+	}{
+		{"raft.append", "raft.propose"},
+		{"raft.replicate", "raft.propose"},
+		{"raft.follower.append", "raft.replicate"},
+		{"raft.commit", "raft.propose"},
+		{"raft.apply", "raft.commit"},
+	}
+	propose, ok := byName["raft.propose"]
+	if !ok {
+		t.Fatalf("no raft.propose span in %v", spans.Spans())
+	}
+	if propose.Parent != client {
+		t.Fatalf("raft.propose parent = %v, want the client's %v", propose.Parent, client)
+	}
+	for _, p := range parents {
+		span, ok := byName[p.span]
+		if !ok {
+			t.Fatalf("no %s span in %v", p.span, spans.Spans())
+		}
+		if span.Context.TraceId != client.TraceId {
+			t.Errorf("%s is in trace %s, want %s", p.span, span.Context.TraceId, client.TraceId)
+		}
+		if span.Parent != byName[p.parent].Context {
+			t.Errorf("%s parent = %v, want %s %v", p.span, span.Parent, p.parent, byName[p.parent].Context)
+		}
+	}
+	if peer := byName["raft.replicate"].Attributes["peer"]; peer != "n2" {
+		t.Errorf("raft.replicate peer = %q, want n2", peer)
+	}
+	if index := byName["raft.commit"].Attributes["index"]; index != "1" {
+		t.Errorf("raft.commit index = %q, want 1", index)
+	}
+}
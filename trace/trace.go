@@ -0,0 +1,185 @@
+// Package trace records the spans of a request as it crosses the nodes of the
+// cluster. Span contexts are propagated in the W3C traceparent format used by
+// OpenTelemetry, so traces can be joined with the ones of the callers. A nil
+// *Tracer, or one without an exporter, records nothing
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span across processes
+type SpanContext struct {
+	TraceId string `json:"trace_id"`
+	SpanId  string `json:"span_id"`
+}
+
+// IsValid tells if the context identifies a span
+func (sc SpanContext) IsValid() bool {
+	return len(sc.TraceId) == 32 && len(sc.SpanId) == 16
+}
+
+// Traceparent returns the context as a W3C traceparent, "" if it isn't valid
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return "00-" + sc.TraceId + "-" + sc.SpanId + "-01"
+}
+
+// ParseTraceparent returns the context of a W3C traceparent, an invalid one if
+// it can't be parsed
+func ParseTraceparent(traceparent string) SpanContext {
+	splits := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(splits) != 4 || len(splits[0]) != 2 || len(splits[3]) != 2 {
+		return SpanContext{}
+	}
+	sc := SpanContext{TraceId: splits[1], SpanId: splits[2]}
+	if !sc.IsValid() || !isHex(sc.TraceId) || !isHex(sc.SpanId) ||
+		sc.TraceId == strings.Repeat("0", 32) || sc.SpanId == strings.Repeat("0", 16) {
+		return SpanContext{}
+	}
+	return sc
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SpanData is a finished span as handed to exporters
+type SpanData struct {
+	Name       string            `json:"name"`
+	Context    SpanContext       `json:"context"`
+	Parent     SpanContext       `json:"parent"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Duration is the time the span took
+func (d SpanData) Duration() time.Duration {
+	return d.End.Sub(d.Start)
+}
+
+// Exporter receives every span once it has ended
+type Exporter interface {
+	Export(span SpanData)
+}
+
+// Tracer starts spans and hands them to its exporter
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a tracer exporting to exporter, nil records nothing
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Span is an operation being timed, the methods of a nil *Span do nothing so
+// callers don't need to check if tracing is enabled
+type Span struct {
+	mu       sync.Mutex
+	data     SpanData
+	exporter Exporter
+	ended    bool
+}
+
+// Start starts a span, a child of parent if it's valid and the root of a new
+// trace otherwise. It returns nil when the tracer records nothing
+func (t *Tracer) Start(parent SpanContext, name string) *Span {
+	if t == nil || t.exporter == nil {
+		return nil
+	}
+	sc := SpanContext{TraceId: parent.TraceId, SpanId: randomHex(8)}
+	if !parent.IsValid() {
+		sc.TraceId = randomHex(16)
+		parent = SpanContext{}
+	}
+	return &Span{
+		data:     SpanData{Name: name, Context: sc, Parent: parent, Start: time.Now(), Attributes: map[string]string{}},
+		exporter: t.exporter,
+	}
+}
+
+// Context returns the context of the span, an invalid one for a nil span
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetAttribute annotates the span
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes[key] = value
+}
+
+// End finishes the span and exports it, ending it again does nothing
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.exporter.Export(data)
+}
+
+// InMemoryExporter keeps the last spans it was handed, for tests and for
+// looking at recent traces of a running node
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	limit int
+	spans []SpanData
+}
+
+// NewInMemoryExporter keeps up to limit spans, all of them if limit is 0
+func NewInMemoryExporter(limit int) *InMemoryExporter {
+	return &InMemoryExporter{limit: limit}
+}
+
+func (e *InMemoryExporter) Export(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+	if e.limit > 0 && len(e.spans) > e.limit {
+		e.spans = e.spans[len(e.spans)-e.limit:]
+	}
+}
+
+// Spans returns the kept spans in the order they ended
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset drops the kept spans
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}